@@ -0,0 +1,260 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ArgsBuilder builds and validates Args.
+// Setting a field twice or setting options that have no effect
+// in combination, e.g. StripLoudComments without OutputStyleCompressed,
+// is an error reported by Build.
+type ArgsBuilder struct {
+	args Args
+	set  map[string]bool
+	errs []error
+}
+
+// NewArgs creates a new ArgsBuilder.
+func NewArgs() *ArgsBuilder {
+	return &ArgsBuilder{set: make(map[string]bool)}
+}
+
+func (b *ArgsBuilder) mark(field string) {
+	if b.set[field] {
+		b.errs = append(b.errs, fmt.Errorf("%s set more than once", field))
+	}
+	b.set[field] = true
+}
+
+// Source sets Args.Source.
+func (b *ArgsBuilder) Source(s string) *ArgsBuilder {
+	b.mark("Source")
+	b.args.Source = s
+	return b
+}
+
+// SourceBytes sets Args.SourceBytes.
+func (b *ArgsBuilder) SourceBytes(s []byte) *ArgsBuilder {
+	b.mark("SourceBytes")
+	b.args.SourceBytes = s
+	return b
+}
+
+// Path sets Args.Path.
+func (b *ArgsBuilder) Path(filename string) *ArgsBuilder {
+	b.mark("Path")
+	b.args.Path = filename
+	return b
+}
+
+// VirtualFiles sets Args.VirtualFiles and Args.Entry.
+func (b *ArgsBuilder) VirtualFiles(entry string, files map[string]string) *ArgsBuilder {
+	b.mark("VirtualFiles")
+	b.args.Entry = entry
+	b.args.VirtualFiles = files
+	return b
+}
+
+// URL sets Args.URL.
+func (b *ArgsBuilder) URL(u string) *ArgsBuilder {
+	b.mark("URL")
+	b.args.URL = u
+	return b
+}
+
+// SourceSyntax sets Args.SourceSyntax.
+func (b *ArgsBuilder) SourceSyntax(s SourceSyntax) *ArgsBuilder {
+	b.mark("SourceSyntax")
+	b.args.SourceSyntax = s
+	return b
+}
+
+// OutputStyle sets Args.OutputStyle.
+func (b *ArgsBuilder) OutputStyle(s OutputStyle) *ArgsBuilder {
+	b.mark("OutputStyle")
+	b.args.OutputStyle = s
+	return b
+}
+
+// Charset sets Args.Charset.
+func (b *ArgsBuilder) Charset(charset bool) *ArgsBuilder {
+	b.mark("Charset")
+	b.args.Charset = &charset
+	return b
+}
+
+// SourceMap enables source maps, optionally with the sources embedded.
+func (b *ArgsBuilder) SourceMap(includeSources bool) *ArgsBuilder {
+	b.mark("EnableSourceMap")
+	b.args.EnableSourceMap = true
+	b.args.SourceMapIncludeSources = includeSources
+	return b
+}
+
+// SourceMapRelativeTo sets Args.SourceMapRelativeTo.
+func (b *ArgsBuilder) SourceMapRelativeTo(dir string) *ArgsBuilder {
+	b.mark("SourceMapRelativeTo")
+	b.args.SourceMapRelativeTo = dir
+	return b
+}
+
+// ImportResolver sets Args.ImportResolver.
+func (b *ArgsBuilder) ImportResolver(r ImportResolver) *ArgsBuilder {
+	b.mark("ImportResolver")
+	b.args.ImportResolver = r
+	return b
+}
+
+// ImportResolvers sets Args.ImportResolvers.
+func (b *ArgsBuilder) ImportResolvers(r ...ImportResolver) *ArgsBuilder {
+	b.mark("ImportResolvers")
+	b.args.ImportResolvers = r
+	return b
+}
+
+// EntryImporter sets Args.EntryImporter.
+func (b *ArgsBuilder) EntryImporter(r ImportResolver) *ArgsBuilder {
+	b.mark("EntryImporter")
+	b.args.EntryImporter = r
+	return b
+}
+
+// IncludePaths sets Args.IncludePaths.
+func (b *ArgsBuilder) IncludePaths(paths ...string) *ArgsBuilder {
+	b.mark("IncludePaths")
+	b.args.IncludePaths = paths
+	return b
+}
+
+// Variables sets Args.Variables and Args.VariablesGlobal.
+func (b *ArgsBuilder) Variables(vars map[string]string, global bool) *ArgsBuilder {
+	b.mark("Variables")
+	b.args.Variables = vars
+	b.args.VariablesGlobal = global
+	return b
+}
+
+// Functions sets Args.Functions.
+func (b *ArgsBuilder) Functions(functions map[string]CustomFunction) *ArgsBuilder {
+	b.mark("Functions")
+	b.args.Functions = functions
+	return b
+}
+
+// SilenceDeprecations sets Args.SilenceDeprecations.
+func (b *ArgsBuilder) SilenceDeprecations(ids ...string) *ArgsBuilder {
+	b.mark("SilenceDeprecations")
+	b.args.SilenceDeprecations = ids
+	return b
+}
+
+// FutureDeprecations sets Args.FutureDeprecations.
+func (b *ArgsBuilder) FutureDeprecations(ids ...string) *ArgsBuilder {
+	b.mark("FutureDeprecations")
+	b.args.FutureDeprecations = ids
+	return b
+}
+
+// FatalDeprecations sets Args.FatalDeprecations.
+func (b *ArgsBuilder) FatalDeprecations(ids ...string) *ArgsBuilder {
+	b.mark("FatalDeprecations")
+	b.args.FatalDeprecations = ids
+	return b
+}
+
+// RequireUse sets Args.RequireUse.
+func (b *ArgsBuilder) RequireUse() *ArgsBuilder {
+	b.mark("RequireUse")
+	b.args.RequireUse = true
+	return b
+}
+
+// SilenceDependencyDeprecations sets Args.SilenceDependencyDeprecations.
+func (b *ArgsBuilder) SilenceDependencyDeprecations() *ArgsBuilder {
+	b.mark("SilenceDependencyDeprecations")
+	b.args.SilenceDependencyDeprecations = true
+	return b
+}
+
+// QuietDeprecations sets Args.QuietDeprecations.
+func (b *ArgsBuilder) QuietDeprecations() *ArgsBuilder {
+	b.mark("QuietDeprecations")
+	b.args.QuietDeprecations = true
+	return b
+}
+
+// Verbose sets Args.Verbose.
+func (b *ArgsBuilder) Verbose() *ArgsBuilder {
+	b.mark("Verbose")
+	b.args.Verbose = true
+	return b
+}
+
+// Quiet sets Args.Quiet.
+func (b *ArgsBuilder) Quiet() *ArgsBuilder {
+	b.mark("Quiet")
+	b.args.Quiet = true
+	return b
+}
+
+// StripLoudComments sets Args.StripLoudComments.
+func (b *ArgsBuilder) StripLoudComments() *ArgsBuilder {
+	b.mark("StripLoudComments")
+	b.args.StripLoudComments = true
+	return b
+}
+
+// MaxOutputBytes sets Args.MaxOutputBytes.
+func (b *ArgsBuilder) MaxOutputBytes(n int) *ArgsBuilder {
+	b.mark("MaxOutputBytes")
+	b.args.MaxOutputBytes = n
+	return b
+}
+
+// Indent sets Args.IndentWidth and Args.IndentType.
+func (b *ArgsBuilder) Indent(width int, typ IndentType) *ArgsBuilder {
+	b.mark("Indent")
+	b.args.IndentWidth = width
+	b.args.IndentType = typ
+	return b
+}
+
+// Build validates and returns the Args.
+func (b *ArgsBuilder) Build() (Args, error) {
+	errs := b.errs
+
+	if b.set["Source"] && b.set["SourceBytes"] {
+		errs = append(errs, errors.New("SourceBytes has no effect with Source set"))
+	}
+	if b.args.StripLoudComments && b.args.OutputStyle != OutputStyleCompressed {
+		errs = append(errs, errors.New("StripLoudComments requires OutputStyleCompressed"))
+	}
+	if b.set["Indent"] && b.args.OutputStyle == OutputStyleCompressed {
+		errs = append(errs, errors.New("Indent has no effect with OutputStyleCompressed"))
+	}
+	if b.set["SourceMapRelativeTo"] && !b.args.EnableSourceMap {
+		errs = append(errs, errors.New("SourceMapRelativeTo requires SourceMap"))
+	}
+	if b.args.EntryImporter != nil && b.args.URL == "" {
+		errs = append(errs, errors.New("EntryImporter requires URL"))
+	}
+	if b.args.MaxOutputBytes < 0 {
+		errs = append(errs, errors.New("MaxOutputBytes must not be negative"))
+	}
+
+	// Validate the values as Execute would, leaving b.args untouched.
+	args := b.args
+	if err := args.init(Options{}); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return Args{}, errors.Join(errs...)
+	}
+
+	return b.args, nil
+}
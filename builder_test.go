@@ -0,0 +1,55 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestArgsBuilder(t *testing.T) {
+	c := qt.New(t)
+
+	args, err := NewArgs().
+		Source("a{color:vars.$primary}").
+		URL("file:///my/main.scss").
+		OutputStyle(OutputStyleCompressed).
+		SourceMap(true).
+		Variables(map[string]string{"primary": "#333"}, false).
+		StripLoudComments().
+		Build()
+	c.Assert(err, qt.IsNil)
+	c.Assert(args.Source, qt.Equals, "a{color:vars.$primary}")
+	c.Assert(args.URL, qt.Equals, "file:///my/main.scss")
+	c.Assert(args.OutputStyle, qt.Equals, OutputStyleCompressed)
+	c.Assert(args.EnableSourceMap, qt.IsTrue)
+	c.Assert(args.SourceMapIncludeSources, qt.IsTrue)
+	c.Assert(args.StripLoudComments, qt.IsTrue)
+	c.Assert(args.sassImporters, qt.IsNil)
+
+	_, err = NewArgs().Source("a").Source("b").Build()
+	c.Assert(err, qt.ErrorMatches, "Source set more than once")
+
+	_, err = NewArgs().Source("a").SourceBytes([]byte("b")).Build()
+	c.Assert(err, qt.ErrorMatches, "SourceBytes has no effect with Source set")
+
+	_, err = NewArgs().StripLoudComments().Build()
+	c.Assert(err, qt.ErrorMatches, "StripLoudComments requires OutputStyleCompressed")
+
+	_, err = NewArgs().OutputStyle(OutputStyleCompressed).Indent(4, IndentTypeSpace).Build()
+	c.Assert(err, qt.ErrorMatches, "Indent has no effect with OutputStyleCompressed")
+
+	_, err = NewArgs().SourceMapRelativeTo("/project").Build()
+	c.Assert(err, qt.ErrorMatches, "SourceMapRelativeTo requires SourceMap")
+
+	_, err = NewArgs().EntryImporter(testResolver{}).MaxOutputBytes(-1).Build()
+	c.Assert(err, qt.ErrorMatches, "EntryImporter requires URL\nMaxOutputBytes must not be negative")
+
+	_, err = NewArgs().OutputStyle("NESTED").Build()
+	var invalidErr *InvalidOptionError
+	c.Assert(errors.As(err, &invalidErr), qt.IsTrue)
+	c.Assert(invalidErr.Field, qt.Equals, "OutputStyle")
+}
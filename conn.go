@@ -12,6 +12,7 @@ import (
 	"os/exec"
 	"regexp"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -29,7 +30,7 @@ func newConn(cmd *exec.Cmd) (_ conn, err error) {
 	out, err := cmd.StdoutPipe()
 	stdErr := &tailBuffer{limit: 1024}
 	buff := bufio.NewReader(out)
-	c := conn{buff, buff, out, in, stdErr, cmd}
+	c := conn{buff, buff, out, in, stdErr, cmd, &exitWaiter{done: make(chan struct{})}}
 	cmd.Stderr = c.stdErr
 
 	return c, err
@@ -40,6 +41,18 @@ type byteReadWriteCloser interface {
 	io.ByteReader
 }
 
+type killer interface {
+	Kill() error
+}
+
+type stderrTailer interface {
+	stderr() string
+}
+
+type exitStderrer interface {
+	exitStderr(timeout time.Duration) string
+}
+
 type conn struct {
 	io.ByteReader
 	io.Reader
@@ -47,17 +60,41 @@ type conn struct {
 	io.WriteCloser
 	stdErr *tailBuffer
 	cmd    *exec.Cmd
+	exit   *exitWaiter
+}
+
+// exitWaiter calls cmd.Wait once, as both Close and the reader of a
+// process that died may wait for it to exit.
+type exitWaiter struct {
+	once sync.Once
+	done chan struct{}
+	err  error
+}
+
+func (w *exitWaiter) wait(cmd *exec.Cmd) <-chan struct{} {
+	w.once.Do(func() {
+		go func() {
+			w.err = cmd.Wait()
+			close(w.done)
+		}()
+	})
+	return w.done
 }
 
 // Start starts conn's Cmd.
 func (c conn) Start() error {
 	err := c.cmd.Start()
 	if err != nil {
-		return c.Close()
+		c.closePipes()
 	}
 	return err
 }
 
+func (c conn) closePipes() {
+	c.WriteCloser.Close()
+	c.readerCloser.Close()
+}
+
 // Close closes conn's WriteCloser, ReadClosers, and waits for the command to finish.
 func (c conn) Close() error {
 	writeErr := c.WriteCloser.Close()
@@ -89,15 +126,22 @@ func (c conn) Close() error {
 	return cmdErr
 }
 
+// Kill kills conn's Cmd and closes conn.
+func (c conn) Kill() error {
+	if err := c.cmd.Process.Kill(); err != nil && err != os.ErrProcessDone {
+		return err
+	}
+	return c.Close()
+}
+
 var brokenPipeRe = regexp.MustCompile("Broken pipe|pipe is being closed")
 
 // dart-sass ends on itself on EOF, this is just to give it some
 // time to do so.
 func (c conn) waitWithTimeout() error {
-	result := make(chan error, 1)
-	go func() { result <- c.cmd.Wait() }()
 	select {
-	case err := <-result:
+	case <-c.exit.wait(c.cmd):
+		err := c.exit.err
 		if eerr, ok := err.(*exec.ExitError); ok {
 			if eerr.Error() == "signal: interrupt" {
 				return nil
@@ -113,15 +157,40 @@ func (c conn) waitWithTimeout() error {
 	}
 }
 
+// stderr returns the tail of what conn's Cmd has written to stderr.
+func (c conn) stderr() string {
+	return c.stdErr.String()
+}
+
+// exitStderr waits up to timeout for conn's Cmd to exit, so all of its
+// stderr has been copied, and returns the tail of it.
+func (c conn) exitStderr(timeout time.Duration) string {
+	select {
+	case <-c.exit.wait(c.cmd):
+	case <-time.After(timeout):
+	}
+	return c.stderr()
+}
+
 type tailBuffer struct {
 	limit int
-	bytes.Buffer
+
+	mu  sync.Mutex
+	buf bytes.Buffer
 }
 
 func (b *tailBuffer) Write(p []byte) (n int, err error) {
-	if len(p)+b.Buffer.Len() > b.limit {
-		b.Reset()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(p)+b.buf.Len() > b.limit {
+		b.buf.Reset()
 	}
-	n, err = b.Buffer.Write(p)
+	n, err = b.buf.Write(p)
 	return
 }
+
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,699 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+	"github.com/bep/godartsass/v2/internal/framing"
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeCompilerEnv is set when the test binary is started as a fake Dart Sass
+// process, see startFakeTranspiler.
+const fakeCompilerEnv = "GODARTSASS_TEST_FAKE_COMPILER"
+
+func TestMain(m *testing.M) {
+	if behavior := os.Getenv(fakeCompilerEnv); behavior != "" {
+		os.Exit(runFakeCompiler(behavior))
+	}
+	os.Exit(m.Run())
+}
+
+// runFakeCompiler runs the test binary as a fake Dart Sass process.
+func runFakeCompiler(behavior string) int {
+	switch behavior {
+	case "hang":
+		// Read requests, but never respond to compile requests.
+		serveFake(os.Stdin, os.Stdout, func(uint32, *embeddedsass.InboundMessage, fakeSender) {})
+		return 0
+	case "stderr":
+		// Write the source of each compile request to stderr.
+		serveFake(os.Stdin, os.Stdout, func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+			if req := msg.GetCompileRequest(); req != nil {
+				fmt.Fprintln(os.Stderr, req.GetString_().GetSource())
+			}
+			echoCompileHandler(compilationID, msg, send)
+		})
+		return 0
+	case "fail":
+		fmt.Fprintln(os.Stderr, "boom: missing dependency")
+		return 1
+	default:
+		return 1
+	}
+}
+
+// serveFake reads inbound messages from r until EOF and passes them to
+// handler, writing any responses to w.
+// Version requests are handled by serveFake.
+func serveFake(r io.Reader, w io.Writer, handler fakeHandler) {
+	var mu sync.Mutex
+	fr, fw := framing.NewReader(r), framing.NewWriter(w)
+	send := func(compilationID uint32, msg *embeddedsass.OutboundMessage) {
+		b, err := proto.Marshal(msg)
+		if err != nil {
+			panic(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		fw.WriteFrame(compilationID, b)
+	}
+
+	for {
+		compilationID, payload, err := fr.ReadFrame()
+		if err != nil {
+			return
+		}
+		var msg embeddedsass.InboundMessage
+		if err := proto.Unmarshal(payload, &msg); err != nil {
+			panic(err)
+		}
+		if req := msg.GetVersionRequest(); req != nil {
+			send(compilationID, &embeddedsass.OutboundMessage{
+				Message: &embeddedsass.OutboundMessage_VersionResponse_{
+					VersionResponse: &embeddedsass.OutboundMessage_VersionResponse{
+						Id:                    req.Id,
+						ProtocolVersion:       "3.1.0",
+						CompilerVersion:       "1.80.5",
+						ImplementationVersion: "1.80.5",
+						ImplementationName:    "fake",
+					},
+				},
+			})
+			continue
+		}
+		handler(compilationID, &msg, send)
+	}
+}
+
+// startFakeTranspiler starts a Transpiler backed by the test binary
+// running as a fake Dart Sass process with the given behavior.
+func startFakeTranspiler(c *qt.C, behavior string, opts Options) *Transpiler {
+	c.Setenv(fakeCompilerEnv, behavior)
+	opts.DartSassEmbeddedFilename = os.Args[0]
+	transpiler, err := Start(opts)
+	c.Assert(err, qt.IsNil)
+	return transpiler
+}
+
+// fakeHandler handles an inbound message in a fake compiler,
+// writing any responses using send.
+type fakeHandler func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender)
+
+type fakeSender func(compilationID uint32, msg *embeddedsass.OutboundMessage)
+
+// fakeConn is an in-process connection to a fake compiler built on the
+// protocol framing.
+type fakeConn struct {
+	*bufio.Reader
+	io.WriteCloser
+	out    *io.PipeReader
+	outRaw *io.PipeWriter
+}
+
+func (c *fakeConn) Close() error {
+	c.WriteCloser.Close()
+	return c.out.Close()
+}
+
+// writeRaw writes b to the Transpiler's end of the connection as is.
+func (c *fakeConn) writeRaw(b []byte) {
+	c.outRaw.Write(b)
+}
+
+func newFakeConn(handler fakeHandler) *fakeConn {
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+
+	go func() {
+		defer outw.Close()
+		serveFake(inr, outw, handler)
+	}()
+
+	return &fakeConn{Reader: bufio.NewReader(outr), WriteCloser: inw, out: outr, outRaw: outw}
+}
+
+// newFakeConnTranspiler creates a Transpiler connected to an in-process
+// fake compiler using handler.
+func newFakeConnTranspiler(c *qt.C, opts Options, handler fakeHandler) (*Transpiler, *fakeConn) {
+	c.Assert(opts.init(), qt.IsNil)
+	var conn *fakeConn
+	transpiler, err := newTranspiler(opts, func() (byteReadWriteCloser, error) {
+		conn = newFakeConn(handler)
+		return conn, nil
+	})
+	c.Assert(err, qt.IsNil)
+	return transpiler, conn
+}
+
+// compileSuccess returns a successful compile response.
+func compileSuccess(css, sourceMap string) *embeddedsass.OutboundMessage {
+	return &embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_CompileResponse_{
+			CompileResponse: &embeddedsass.OutboundMessage_CompileResponse{
+				Result: &embeddedsass.OutboundMessage_CompileResponse_Success{
+					Success: &embeddedsass.OutboundMessage_CompileResponse_CompileSuccess{Css: css, SourceMap: sourceMap},
+				},
+			},
+		},
+	}
+}
+
+// compileFailure returns a failed compile response.
+func compileFailure(message string, span *embeddedsass.SourceSpan) *embeddedsass.OutboundMessage {
+	return &embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_CompileResponse_{
+			CompileResponse: &embeddedsass.OutboundMessage_CompileResponse{
+				Result: &embeddedsass.OutboundMessage_CompileResponse_Failure{
+					Failure: &embeddedsass.OutboundMessage_CompileResponse_CompileFailure{Message: message, Span: span},
+				},
+			},
+		},
+	}
+}
+
+func canonicalizeRequest(id, importerID uint32, url string) *embeddedsass.OutboundMessage {
+	return &embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_CanonicalizeRequest_{
+			CanonicalizeRequest: &embeddedsass.OutboundMessage_CanonicalizeRequest{Id: id, ImporterId: importerID, Url: url},
+		},
+	}
+}
+
+func importRequest(id, importerID uint32, url string) *embeddedsass.OutboundMessage {
+	return &embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_ImportRequest_{
+			ImportRequest: &embeddedsass.OutboundMessage_ImportRequest{Id: id, ImporterId: importerID, Url: url},
+		},
+	}
+}
+
+func logEvent(e *embeddedsass.OutboundMessage_LogEvent) *embeddedsass.OutboundMessage {
+	return &embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_LogEvent_{LogEvent: e},
+	}
+}
+
+// echoCompileHandler responds to compile requests with the source as CSS.
+func echoCompileHandler(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+	req := msg.GetCompileRequest()
+	if req == nil {
+		return
+	}
+	send(compilationID, compileSuccess(req.GetString_().GetSource(), ""))
+}
+
+// newResponseHandler returns a handler responding to each compile request
+// with resp.
+func newResponseHandler(resp *embeddedsass.OutboundMessage) fakeHandler {
+	return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if msg.GetCompileRequest() != nil {
+			send(compilationID, resp)
+		}
+	}
+}
+
+// withLogEvents returns a handler that for each compile request sends the
+// log events returned by events before passing the message on to next.
+func withLogEvents(events func(compilationID uint32, req *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent, next fakeHandler) fakeHandler {
+	return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if req := msg.GetCompileRequest(); req != nil {
+			for _, e := range events(compilationID, req) {
+				send(compilationID, logEvent(e))
+			}
+		}
+		next(compilationID, msg, send)
+	}
+}
+
+// compileRequests records the compile requests received by a fake compiler.
+type compileRequests struct {
+	mu   sync.Mutex
+	reqs []*embeddedsass.InboundMessage_CompileRequest
+}
+
+// handler returns a handler recording the compile requests before passing
+// the messages on to next.
+func (r *compileRequests) handler(next fakeHandler) fakeHandler {
+	return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if req := msg.GetCompileRequest(); req != nil {
+			r.mu.Lock()
+			r.reqs = append(r.reqs, req)
+			r.mu.Unlock()
+		}
+		next(compilationID, msg, send)
+	}
+}
+
+func (r *compileRequests) all() []*embeddedsass.InboundMessage_CompileRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reqs
+}
+
+func (r *compileRequests) last() *embeddedsass.InboundMessage_CompileRequest {
+	reqs := r.all()
+	if len(reqs) == 0 {
+		return nil
+	}
+	return reqs[len(reqs)-1]
+}
+
+// newImportCompileHandler returns a handler that for each compile request
+// imports url using the first importer, responding with the loaded
+// contents as CSS.
+func newImportCompileHandler(url string) fakeHandler {
+	var mu sync.Mutex
+	importerIDs := make(map[uint32]uint32)
+
+	return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			if len(m.CompileRequest.Importers) == 0 {
+				send(compilationID, compileFailure("no importers", nil))
+				return
+			}
+			importerID := m.CompileRequest.Importers[0].GetImporterId()
+			importerIDs[compilationID] = importerID
+			send(compilationID, canonicalizeRequest(1, importerID, url))
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			switch r := m.CanonicalizeResponse.Result.(type) {
+			case *embeddedsass.InboundMessage_CanonicalizeResponse_Url:
+				send(compilationID, importRequest(2, importerIDs[compilationID], r.Url))
+			case *embeddedsass.InboundMessage_CanonicalizeResponse_Error:
+				send(compilationID, compileFailure(r.Error, nil))
+			default:
+				send(compilationID, compileFailure("not found", nil))
+			}
+		case *embeddedsass.InboundMessage_ImportResponse_:
+			switch r := m.ImportResponse.Result.(type) {
+			case *embeddedsass.InboundMessage_ImportResponse_Success:
+				send(compilationID, compileSuccess(r.Success.Contents, ""))
+			case *embeddedsass.InboundMessage_ImportResponse_Error:
+				send(compilationID, compileFailure(r.Error, nil))
+			}
+		}
+	}
+}
+
+type fakeImportResolver struct {
+	content string
+}
+
+func (r fakeImportResolver) CanonicalizeURL(url string) (string, error) {
+	return "file:///" + url + ".scss", nil
+}
+
+func (r fakeImportResolver) Load(url string) (Import, error) {
+	return Import{Content: r.content + " " + url}, nil
+}
+
+func TestFakeConnTranspiler(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+				c.Check(err, qt.IsNil)
+				c.Check(result.CSS, qt.Equals, "a{b:c}")
+				c.Check(result.EffectiveOutputStyle, qt.Equals, OutputStyleExpanded)
+			}
+		}()
+	}
+	wg.Wait()
+
+	c.Assert(transpiler.Close(), qt.IsNil)
+}
+
+func TestFakeConnInvalidFrame(t *testing.T) {
+	c := qt.New(t)
+
+	var conn *fakeConn
+	transpiler, conn := newFakeConnTranspiler(c, Options{}, func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		// A zero length frame.
+		conn.writeRaw([]byte{0})
+	})
+	defer transpiler.Close()
+
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "invalid frame.*")
+
+	// The connection is not usable after a desync.
+	_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, ErrShutdown)
+}
+
+func (t *Transpiler) testingCmd() *exec.Cmd {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn.(conn).cmd
+}
+
+func TestKillOnTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{Timeout: 200 * time.Millisecond, KillOnTimeout: true})
+	defer transpiler.Close()
+
+	cmd := transpiler.testingCmd()
+
+	_, err := transpiler.Execute(Args{Source: "a { color: red; }"})
+	c.Assert(err, qt.ErrorMatches, "timeout waiting for Dart Sass to respond.*")
+
+	// The hanging process should be gone and replaced with a new one.
+	c.Assert(cmd.ProcessState, qt.Not(qt.IsNil))
+	newCmd := transpiler.testingCmd()
+	c.Assert(newCmd.ProcessState, qt.IsNil)
+	c.Assert(newCmd.Process.Pid, qt.Not(qt.Equals), cmd.Process.Pid)
+}
+
+func TestTimeoutWithoutKill(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{Timeout: 200 * time.Millisecond})
+	defer transpiler.Close()
+
+	cmd := transpiler.testingCmd()
+
+	_, err := transpiler.Execute(Args{Source: "a { color: red; }"})
+	c.Assert(err, qt.ErrorMatches, "timeout waiting for Dart Sass to respond.*")
+	c.Assert(transpiler.testingCmd(), qt.Equals, cmd)
+	c.Assert(cmd.ProcessState, qt.IsNil)
+}
+
+func TestStartRetries(t *testing.T) {
+	c := qt.New(t)
+
+	failingTimes := func(n int) (func() error, *int) {
+		var attempts int
+		return func() error {
+			attempts++
+			if attempts <= n {
+				return errors.New("resource temporarily unavailable")
+			}
+			return nil
+		}, &attempts
+	}
+
+	c.Setenv(fakeCompilerEnv, "hang")
+
+	startErr, attempts := failingTimes(1)
+	_, err := Start(Options{DartSassEmbeddedFilename: os.Args[0], testingStartErr: startErr})
+	c.Assert(err, qt.ErrorMatches, "resource temporarily unavailable")
+	c.Assert(*attempts, qt.Equals, 1)
+
+	startErr, attempts = failingTimes(1)
+	transpiler, err := Start(Options{DartSassEmbeddedFilename: os.Args[0], StartRetries: 2, testingStartErr: startErr})
+	c.Assert(err, qt.IsNil)
+	c.Assert(*attempts, qt.Equals, 2)
+	c.Assert(transpiler.Close(), qt.IsNil)
+
+	startErr, attempts = failingTimes(5)
+	_, err = Start(Options{DartSassEmbeddedFilename: os.Args[0], StartRetries: 2, testingStartErr: startErr})
+	c.Assert(err, qt.ErrorMatches, "resource temporarily unavailable")
+	c.Assert(*attempts, qt.Equals, 3)
+}
+
+func TestStartFailureIncludesStderr(t *testing.T) {
+	c := qt.New(t)
+
+	c.Setenv(fakeCompilerEnv, "fail")
+	_, err := Start(Options{DartSassEmbeddedFilename: os.Args[0], VerifyStart: true})
+	c.Assert(err, qt.ErrorMatches, "failed to start Dart Sass:.*boom: missing dependency")
+
+	// Without VerifyStart, the failure surfaces on first use.
+	transpiler, err := Start(Options{DartSassEmbeddedFilename: os.Args[0], Stderr: io.Discard})
+	c.Assert(err, qt.IsNil)
+	_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "unexpected EOF: boom: missing dependency")
+	transpiler.Close()
+}
+
+func TestLastStderr(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "stderr", Options{})
+	defer transpiler.Close()
+
+	c.Assert(transpiler.LastStderr(), qt.Equals, "")
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+
+	// Stderr is copied from the process in its own goroutine.
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(transpiler.LastStderr(), "a{b:c}") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(transpiler.LastStderr(), qt.Contains, "a{b:c}")
+}
+
+func TestRestart(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{})
+
+	cmd := transpiler.testingCmd()
+	c.Assert(transpiler.Restart(), qt.IsNil)
+	c.Assert(cmd.ProcessState, qt.Not(qt.IsNil))
+	c.Assert(transpiler.testingCmd().Process.Pid, qt.Not(qt.Equals), cmd.Process.Pid)
+
+	c.Assert(transpiler.Close(), qt.IsNil)
+	c.Assert(transpiler.Restart(), qt.Equals, ErrShutdown)
+}
+
+// staleConn is a connection replaced by a restart with messages still
+// to be read.
+type staleConn struct {
+	*bytes.Reader
+	written bytes.Buffer
+}
+
+func newStaleConn(c *qt.C, compilationID uint32, msgs ...*embeddedsass.OutboundMessage) *staleConn {
+	var buf bytes.Buffer
+	w := framing.NewWriter(&buf)
+	for _, msg := range msgs {
+		b, err := proto.Marshal(msg)
+		c.Assert(err, qt.IsNil)
+		c.Assert(w.WriteFrame(compilationID, b), qt.IsNil)
+	}
+	return &staleConn{Reader: bytes.NewReader(buf.Bytes())}
+}
+
+func (c *staleConn) Write(p []byte) (int, error) { return c.written.Write(p) }
+func (c *staleConn) Close() error                { return nil }
+
+func TestRestartDropsStaleMessages(t *testing.T) {
+	c := qt.New(t)
+
+	// Records the inbound messages other than compile requests, which
+	// are never answered.
+	var (
+		mu      sync.Mutex
+		inbound []*embeddedsass.InboundMessage
+	)
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if msg.GetCompileRequest() == nil {
+			mu.Lock()
+			inbound = append(inbound, msg)
+			mu.Unlock()
+		}
+	}
+
+	var events []LogEvent
+	opts := Options{LogEventHandler: func(e LogEvent) { events = append(events, e) }}
+	transpiler, _ := newFakeConnTranspiler(c, opts, handler)
+	defer transpiler.Close()
+
+	done := make(chan error)
+	go func() {
+		_, err := transpiler.Execute(Args{Source: "a{b:c}", ImportResolver: fakeImportResolver{}})
+		done <- err
+	}()
+	for transpiler.TestingSeq() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	id := transpiler.TestingSeq()
+
+	// Messages for the pending call read from the connection of a
+	// process killed by a restart.
+	stale := newStaleConn(c, id,
+		logEvent(&embeddedsass.OutboundMessage_LogEvent{Type: embeddedsass.LogEventType_WARNING, Message: "stale"}),
+		canonicalizeRequest(1, 1, "colors"),
+	)
+	transpiler.input(stale)
+
+	c.Assert(events, qt.HasLen, 0)
+	c.Assert(stale.written.Len(), qt.Equals, 0)
+	mu.Lock()
+	c.Assert(inbound, qt.HasLen, 0)
+	mu.Unlock()
+
+	c.Assert(transpiler.Close(), qt.IsNil)
+	c.Assert(<-done, qt.Not(qt.IsNil))
+}
+
+func TestRestartReusedSeq(t *testing.T) {
+	c := qt.New(t)
+
+	release := make(chan struct{})
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		go func() {
+			<-release
+			echoCompileHandler(compilationID, msg, send)
+		}()
+	}
+
+	var events []LogEvent
+	opts := Options{LogEventHandler: func(e LogEvent) { events = append(events, e) }}
+	transpiler, _ := newFakeConnTranspiler(c, opts, handler)
+	defer transpiler.Close()
+
+	execute := func() chan error {
+		done := make(chan error, 1)
+		go func() {
+			result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+			if err == nil {
+				c.Check(result.CSS, qt.Equals, "a{b:c}")
+			}
+			done <- err
+		}()
+		for transpiler.TestingSeq() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		return done
+	}
+
+	done := execute()
+	c.Assert(transpiler.Restart(), qt.IsNil)
+	c.Assert(<-done, qt.Equals, ErrRestarted)
+
+	done = execute()
+	c.Assert(transpiler.TestingSeq(), qt.Equals, uint32(1))
+
+	// Late messages from the old process for the reused ID 1.
+	transpiler.input(newStaleConn(c, 1,
+		logEvent(&embeddedsass.OutboundMessage_LogEvent{Type: embeddedsass.LogEventType_WARNING, Message: "stale"}),
+		compileSuccess("stale", ""),
+	))
+
+	close(release)
+	c.Assert(<-done, qt.IsNil)
+	c.Assert(events, qt.HasLen, 0)
+}
+
+func TestIsolatePerCompile(t *testing.T) {
+	c := qt.New(t)
+
+	// Each fake compiler process responds with the number of
+	// compilations it has seen.
+	newCountingHandler := func() fakeHandler {
+		var count int
+		return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+			if msg.GetCompileRequest() == nil {
+				return
+			}
+			count++
+			send(compilationID, compileSuccess(fmt.Sprintf("count: %d", count), ""))
+		}
+	}
+
+	for _, test := range []struct {
+		isolate bool
+		expect  []string
+	}{
+		{false, []string{"count: 1", "count: 2"}},
+		{true, []string{"count: 1", "count: 1"}},
+	} {
+		opts := Options{IsolatePerCompile: test.isolate}
+		c.Assert(opts.init(), qt.IsNil)
+		transpiler, err := newTranspiler(opts, func() (byteReadWriteCloser, error) {
+			return newFakeConn(newCountingHandler()), nil
+		})
+		c.Assert(err, qt.IsNil)
+
+		var results []string
+		for i := 0; i < 2; i++ {
+			result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+			c.Assert(err, qt.IsNil)
+			results = append(results, result.CSS)
+		}
+		c.Assert(results, qt.DeepEquals, test.expect)
+		c.Assert(transpiler.Close(), qt.IsNil)
+	}
+}
+
+func TestCompilationIDs(t *testing.T) {
+	c := qt.New(t)
+
+	var ids []uint32
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if msg.GetCompileRequest() != nil {
+			ids = append(ids, compilationID)
+		}
+		echoCompileHandler(compilationID, msg, send)
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	c.Assert(transpiler.TestingSeq(), qt.Equals, uint32(0))
+	for i := 0; i < 3; i++ {
+		_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(transpiler.TestingSeq(), qt.Equals, uint32(3))
+
+	c.Assert(transpiler.Restart(), qt.IsNil)
+	c.Assert(transpiler.TestingSeq(), qt.Equals, uint32(0))
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(ids, qt.DeepEquals, []uint32{1, 2, 3, 1})
+}
+
+func BenchmarkFakeConnSteadyState(b *testing.B) {
+	c := qt.New(b)
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	defer transpiler.Close()
+
+	large := Args{Source: strings.Repeat("a{b:c}", 10000)}
+	small := Args{Source: "a{b:c}"}
+
+	// Grow the read buffer, then release it so that the measured
+	// allocations are not skewed by the earlier, larger message.
+	_, err := transpiler.Execute(large)
+	c.Assert(err, qt.IsNil)
+	transpiler.TestingResetBuffers()
+	_, err = transpiler.Execute(small)
+	c.Assert(err, qt.IsNil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := transpiler.Execute(small); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// stripLoudComments removes all /*! ... */ comments from css,
+// leaving comment-like sequences inside strings and url() untouched.
+func stripLoudComments(css string) string {
+	if !strings.Contains(css, "/*!") {
+		return css
+	}
+
+	var b strings.Builder
+	b.Grow(len(css))
+
+	for i := 0; i < len(css); {
+		switch {
+		case css[i] == '"' || css[i] == '\'':
+			end := skipString(css, i)
+			b.WriteString(css[i:end])
+			i = end
+		case hasPrefixFold(css[i:], "url("):
+			end := skipURL(css, i)
+			b.WriteString(css[i:end])
+			i = end
+		case strings.HasPrefix(css[i:], "/*"):
+			end := skipComment(css, i)
+			if !strings.HasPrefix(css[i:], "/*!") {
+				b.WriteString(css[i:end])
+			}
+			i = end
+		default:
+			b.WriteByte(css[i])
+			i++
+		}
+	}
+
+	return b.String()
+}
+
+// reindent replaces the two-space indentation Dart Sass uses in
+// expanded output with indent. The lines inside multi-line comments
+// and strings are left as is.
+func reindent(css, indent string) string {
+	const sassIndent = "  "
+	if indent == sassIndent {
+		return css
+	}
+
+	var b strings.Builder
+	b.Grow(len(css))
+	lineStart := true
+	for i := 0; i < len(css); {
+		if lineStart {
+			lineStart = false
+			level := 0
+			for strings.HasPrefix(css[i:], sassIndent) {
+				i += len(sassIndent)
+				level++
+			}
+			b.WriteString(strings.Repeat(indent, level))
+			continue
+		}
+		end := i + 1
+		switch {
+		case css[i] == '"' || css[i] == '\'':
+			end = skipString(css, i)
+		case strings.HasPrefix(css[i:], "/*"):
+			end = skipComment(css, i)
+		case css[i] == '\n':
+			lineStart = true
+		}
+		b.WriteString(css[i:end])
+		i = end
+	}
+	return b.String()
+}
+
+// topLevelStatements returns the start and end offsets of the
+// top-level rules and at-rules in css, skipping comments.
+func topLevelStatements(css string) [][2]int {
+	var (
+		stmts [][2]int
+		depth int
+		start = -1
+	)
+
+	for i := 0; i < len(css); {
+		c := css[i]
+		switch {
+		case c == '"' || c == '\'':
+			if start == -1 {
+				start = i
+			}
+			i = skipString(css, i)
+			continue
+		case strings.HasPrefix(css[i:], "/*"):
+			i = skipComment(css, i)
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+		case start == -1:
+			start = i
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				stmts = append(stmts, [2]int{start, i + 1})
+				start = -1
+			}
+		case c == ';' && depth == 0:
+			stmts = append(stmts, [2]int{start, i + 1})
+			start = -1
+		}
+		i++
+	}
+
+	if start != -1 {
+		stmts = append(stmts, [2]int{start, len(css)})
+	}
+
+	return stmts
+}
+
+// Selectors returns the selectors of the top-level style rules in the CSS,
+// with selector lists split into their individual selectors.
+// At-rules, including their nested rules, and comments are skipped.
+func (r Result) Selectors() ([]string, error) {
+	var selectors []string
+	for _, stmt := range topLevelStatements(r.CSS) {
+		rule := r.CSS[stmt[0]:stmt[1]]
+		if rule[0] == '@' {
+			continue
+		}
+		i := indexOutsideStrings(rule, '{')
+		if i == -1 {
+			return nil, fmt.Errorf("invalid rule %q", rule)
+		}
+		selectors = append(selectors, splitSelectorList(stripComments(rule[:i]))...)
+	}
+	return selectors, nil
+}
+
+// FilterSelectors returns the CSS with only the style rules with a selector
+// matching match, e.g. to extract critical CSS. Selector lists are trimmed to
+// the matching selectors.
+// Rules nested in conditional group rules, e.g. @media and @supports, are
+// filtered the same way, keeping the wrapper if any rule is kept.
+// Other at-rules with a block, e.g. @font-face and @keyframes, and
+// comments are removed, while at-rules without a block,
+// e.g. @charset and @import, are kept.
+func (r Result) FilterSelectors(match func(selector string) bool) (string, error) {
+	return filterSelectors(r.CSS, match, false)
+}
+
+func filterSelectors(css string, match func(selector string) bool, nested bool) (string, error) {
+	var (
+		b       strings.Builder
+		prevEnd int
+	)
+
+	for _, stmt := range topLevelStatements(css) {
+		gap := trailingSpace(css[prevEnd:stmt[0]])
+		prevEnd = stmt[1]
+		rule := css[stmt[0]:stmt[1]]
+		i := indexOutsideStrings(rule, '{')
+
+		var kept string
+		switch {
+		case rule[0] == '@' && i == -1:
+			kept = rule
+		case rule[0] == '@':
+			if !isGroupRule(rule) {
+				continue
+			}
+			end := strings.LastIndexByte(rule, '}')
+			if end < i {
+				return "", fmt.Errorf("invalid rule %q", rule)
+			}
+			inner, err := filterSelectors(rule[i+1:end], match, true)
+			if err != nil {
+				return "", err
+			}
+			if strings.TrimSpace(inner) != "" {
+				kept = rule[:i+1] + inner + trailingSpace(rule[i+1:end]) + rule[end:]
+			}
+		case i == -1:
+			return "", fmt.Errorf("invalid rule %q", rule)
+		default:
+			selectorList := rule[:i]
+			all := splitSelectorList(stripComments(selectorList))
+			var matching []string
+			for _, selector := range all {
+				if match(selector) {
+					matching = append(matching, selector)
+				}
+			}
+			switch len(matching) {
+			case 0:
+			case len(all):
+				kept = rule
+			default:
+				sep := ","
+				if strings.Contains(selectorList, "\n") {
+					sep = ",\n" + gap[strings.LastIndexByte(gap, '\n')+1:]
+				} else if strings.Contains(selectorList, ", ") {
+					sep = ", "
+				}
+				kept = strings.Join(matching, sep) + trailingSpace(selectorList) + rule[i:]
+			}
+		}
+
+		if kept == "" {
+			continue
+		}
+		if b.Len() > 0 || nested {
+			b.WriteString(gap)
+		}
+		b.WriteString(kept)
+	}
+
+	return b.String(), nil
+}
+
+// isGroupRule reports whether rule is an at-rule that may contain style rules.
+func isGroupRule(rule string) bool {
+	name := rule[1:]
+	if i := strings.IndexAny(name, " \t\n{("); i != -1 {
+		name = name[:i]
+	}
+	switch strings.ToLower(name) {
+	case "media", "supports", "container", "layer", "scope", "document", "-moz-document", "starting-style":
+		return true
+	}
+	return false
+}
+
+// trailingSpace returns the whitespace at the end of s.
+func trailingSpace(s string) string {
+	return s[len(strings.TrimRight(s, " \t\n\r\f")):]
+}
+
+// CSSTokenType is the type of a CSSToken.
+type CSSTokenType int
+
+const (
+	// The selector list of a style rule, e.g. ".a, .b".
+	CSSTokenSelector CSSTokenType = iota
+
+	// A declaration, e.g. "color: red".
+	CSSTokenDeclaration
+
+	// An at-rule without its block, e.g. "@media print" or
+	// "@import \"a.css\"".
+	CSSTokenAtRule
+
+	// The end of the block of the last opened style rule or at-rule.
+	CSSTokenBlockEnd
+
+	// A comment, e.g. "/* foo */".
+	CSSTokenComment
+)
+
+// CSSToken is a token in the stream returned by Result.Tokens.
+type CSSToken struct {
+	Type CSSTokenType
+
+	// The trimmed text of the token, empty for CSSTokenBlockEnd.
+	Value string
+
+	// The nesting level, 0 for top-level rules.
+	Depth int
+}
+
+// Tokens returns the CSS as a flat stream of tokens.
+// This is a minimal tokenizer suitable for simple analysis, e.g. in linters,
+// it does not validate the CSS.
+func (r Result) Tokens() ([]CSSToken, error) {
+	var (
+		tokens []CSSToken
+		depth  int
+		start  int
+		parens int
+	)
+
+	css := r.CSS
+
+	flush := func(end int, blockStart bool) {
+		text := strings.TrimSpace(css[start:end])
+		start = end + 1
+		if text == "" {
+			return
+		}
+		typ := CSSTokenDeclaration
+		switch {
+		case text[0] == '@':
+			typ = CSSTokenAtRule
+		case blockStart:
+			typ = CSSTokenSelector
+		}
+		tokens = append(tokens, CSSToken{Type: typ, Value: text, Depth: depth})
+	}
+
+	for i := 0; i < len(css); {
+		switch {
+		case css[i] == '"' || css[i] == '\'':
+			i = skipString(css, i)
+			continue
+		case css[i] == '\\':
+			i += 2
+			continue
+		case strings.HasPrefix(css[i:], "/*"):
+			end := skipComment(css, i)
+			if strings.TrimSpace(css[start:i]) == "" {
+				tokens = append(tokens, CSSToken{Type: CSSTokenComment, Value: css[i:end], Depth: depth})
+				start = end
+			}
+			i = end
+			continue
+		case css[i] == '(':
+			parens++
+		case css[i] == ')':
+			parens--
+		case parens > 0:
+		case css[i] == '{':
+			flush(i, true)
+			depth++
+		case css[i] == ';':
+			flush(i, false)
+		case css[i] == '}':
+			flush(i, false)
+			if depth == 0 {
+				return nil, fmt.Errorf("unexpected } at offset %d", i)
+			}
+			depth--
+			tokens = append(tokens, CSSToken{Type: CSSTokenBlockEnd, Depth: depth})
+		}
+		i++
+	}
+
+	if depth != 0 {
+		return nil, errors.New("unclosed block at end of CSS")
+	}
+	if start < len(css) {
+		flush(len(css), false)
+	}
+
+	return tokens, nil
+}
+
+// splitSelectorList splits s on the commas not inside strings,
+// parentheses or brackets, trimming each selector.
+func splitSelectorList(s string) []string {
+	var (
+		selectors []string
+		depth     int
+		start     int
+	)
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"', '\'':
+			i = skipString(s, i) - 1
+		case '\\':
+			i++
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ',':
+			if depth == 0 {
+				selectors = appendTrimmed(selectors, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return appendTrimmed(selectors, s[start:])
+}
+
+func appendTrimmed(ss []string, s string) []string {
+	if s = strings.TrimSpace(s); s != "" {
+		ss = append(ss, s)
+	}
+	return ss
+}
+
+// indexOutsideStrings returns the index of the first c in s not inside
+// a quoted string or a comment, or -1.
+func indexOutsideStrings(s string, c byte) int {
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == c:
+			return i
+		case s[i] == '"' || s[i] == '\'':
+			i = skipString(s, i)
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipComment(s, i)
+		default:
+			i++
+		}
+	}
+	return -1
+}
+
+// stripComments removes all comments from s.
+func stripComments(s string) string {
+	if !strings.Contains(s, "/*") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			end := skipString(s, i)
+			b.WriteString(s[i:end])
+			i = end
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipComment(s, i)
+		default:
+			b.WriteByte(s[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// skipString returns the index after the quoted string starting at i.
+func skipString(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+// skipComment returns the index after the comment starting at i.
+func skipComment(s string, i int) int {
+	end := strings.Index(s[i+2:], "*/")
+	if end == -1 {
+		return len(s)
+	}
+	return i + 2 + end + 2
+}
+
+// skipURL returns the index after the url() token starting at i.
+func skipURL(s string, i int) int {
+	for j := i + len("url("); j < len(s); j++ {
+		switch s[j] {
+		case '"', '\'':
+			j = skipString(s, j) - 1
+		case '\\':
+			j++
+		case ')':
+			return j + 1
+		}
+	}
+	return len(s)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
@@ -0,0 +1,156 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestStripLoudComments(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(stripLoudComments("div{color:#ccc}"), qt.Equals, "div{color:#ccc}")
+	c.Assert(stripLoudComments("/*! license */div{color:#ccc}/*! foo */"), qt.Equals, "div{color:#ccc}")
+	c.Assert(stripLoudComments("/* keep */div{color:#ccc}"), qt.Equals, "/* keep */div{color:#ccc}")
+	c.Assert(stripLoudComments(`div::before{content:"/*! a */"}`), qt.Equals, `div::before{content:"/*! a */"}`)
+	c.Assert(stripLoudComments(`div::before{content:'a\'/*! b */'}`), qt.Equals, `div::before{content:'a\'/*! b */'}`)
+	c.Assert(stripLoudComments(`div{background:url(/*!a*/b.png)}/*!c*/`), qt.Equals, `div{background:url(/*!a*/b.png)}`)
+	c.Assert(stripLoudComments(`div{background:URL("/*!a*/b.png")}`), qt.Equals, `div{background:URL("/*!a*/b.png")}`)
+	c.Assert(stripLoudComments("div{}/*! unterminated"), qt.Equals, "div{}")
+}
+
+func TestTopLevelStatements(t *testing.T) {
+	c := qt.New(t)
+
+	css := `@import "a;b"; a{b:c}/* x */ @media print{d{e:f}} g{`
+	var stmts []string
+	for _, s := range topLevelStatements(css) {
+		stmts = append(stmts, css[s[0]:s[1]])
+	}
+	c.Assert(stmts, qt.DeepEquals, []string{`@import "a;b";`, "a{b:c}", "@media print{d{e:f}}", "g{"})
+}
+
+func TestResultSelectors(t *testing.T) {
+	c := qt.New(t)
+
+	result := Result{CSS: `@charset "UTF-8";
+/* header */
+.a, .b > p {
+  color: red;
+}
+
+@media print {
+  .c {
+    color: blue;
+  }
+}
+:is(.d, .e) /* x */ a[title="a,b{"], .f {
+  content: "}";
+}
+@font-face {
+  font-family: x;
+}`}
+
+	selectors, err := result.Selectors()
+	c.Assert(err, qt.IsNil)
+	c.Assert(selectors, qt.DeepEquals, []string{".a", ".b > p", ":is(.d, .e)  a[title=\"a,b{\"]", ".f"})
+
+	_, err = Result{CSS: "a;"}.Selectors()
+	c.Assert(err, qt.ErrorMatches, `invalid rule "a;"`)
+}
+
+func TestResultFilterSelectors(t *testing.T) {
+	c := qt.New(t)
+
+	result := Result{CSS: `@charset "UTF-8";
+/* header */
+.a, .b > p {
+  color: red;
+}
+
+.c {
+  color: green;
+}
+
+@media print {
+  .c {
+    color: blue;
+  }
+  .d,
+  .a {
+    color: black;
+  }
+}
+
+@media screen {
+  .d {
+    color: white;
+  }
+}
+
+@font-face {
+  font-family: x;
+}`}
+
+	css, err := result.FilterSelectors(func(selector string) bool { return selector == ".a" })
+	c.Assert(err, qt.IsNil)
+	c.Assert(css, qt.Equals, `@charset "UTF-8";
+.a {
+  color: red;
+}
+
+@media print {
+  .a {
+    color: black;
+  }
+}`)
+
+	css, err = Result{CSS: ".a,.b{color:red}.c{color:blue}@media print{.b{color:#000}}"}.FilterSelectors(func(selector string) bool { return selector == ".b" })
+	c.Assert(err, qt.IsNil)
+	c.Assert(css, qt.Equals, ".b{color:red}@media print{.b{color:#000}}")
+
+	_, err = Result{CSS: "a;"}.FilterSelectors(func(string) bool { return true })
+	c.Assert(err, qt.ErrorMatches, `invalid rule "a;"`)
+}
+
+func TestResultTokens(t *testing.T) {
+	c := qt.New(t)
+
+	result := Result{CSS: `@charset "UTF-8";
+@import url("a;b.css");
+/* header */
+.a, .b {
+  color: red;
+  background: url(data:image/png;base64,iVBOR);
+  content: "};" /* x */
+}
+@media print {
+  .c { color: blue }
+}`}
+
+	tokens, err := result.Tokens()
+	c.Assert(err, qt.IsNil)
+	c.Assert(tokens, qt.DeepEquals, []CSSToken{
+		{Type: CSSTokenAtRule, Value: `@charset "UTF-8"`},
+		{Type: CSSTokenAtRule, Value: `@import url("a;b.css")`},
+		{Type: CSSTokenComment, Value: "/* header */"},
+		{Type: CSSTokenSelector, Value: ".a, .b"},
+		{Type: CSSTokenDeclaration, Value: "color: red", Depth: 1},
+		{Type: CSSTokenDeclaration, Value: "background: url(data:image/png;base64,iVBOR)", Depth: 1},
+		{Type: CSSTokenDeclaration, Value: `content: "};" /* x */`, Depth: 1},
+		{Type: CSSTokenBlockEnd},
+		{Type: CSSTokenAtRule, Value: "@media print"},
+		{Type: CSSTokenSelector, Value: ".c", Depth: 1},
+		{Type: CSSTokenDeclaration, Value: "color: blue", Depth: 2},
+		{Type: CSSTokenBlockEnd, Depth: 1},
+		{Type: CSSTokenBlockEnd},
+	})
+
+	_, err = Result{CSS: "a{}}"}.Tokens()
+	c.Assert(err, qt.ErrorMatches, "unexpected } at offset 3")
+	_, err = Result{CSS: "a{"}.Tokens()
+	c.Assert(err, qt.ErrorMatches, "unclosed block at end of CSS")
+}
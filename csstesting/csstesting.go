@@ -0,0 +1,196 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+// Package csstesting provides helpers to compare CSS in tests.
+package csstesting
+
+import (
+	"strings"
+)
+
+// CSSEqual reports whether a and b are equal after normalizing
+// insignificant whitespace.
+func CSSEqual(a, b string) bool {
+	return Normalize(a) == Normalize(b)
+}
+
+// DiffCSS returns a line based diff of the normalized a and b,
+// with one statement per line, prefixed with "-" (only in a) or
+// "+" (only in b).
+// It returns an empty string if a and b are equal.
+func DiffCSS(a, b string) string {
+	linesa, linesb := statements(Normalize(a)), statements(Normalize(b))
+
+	// Longest common subsequence.
+	lcs := make([][]int, len(linesa)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(linesb)+1)
+	}
+	for i := len(linesa) - 1; i >= 0; i-- {
+		for j := len(linesb) - 1; j >= 0; j-- {
+			if linesa[i] == linesb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var (
+		sb      strings.Builder
+		changed bool
+	)
+	write := func(prefix, line string) {
+		sb.WriteString(prefix)
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+
+	i, j := 0, 0
+	for i < len(linesa) && j < len(linesb) {
+		switch {
+		case linesa[i] == linesb[j]:
+			write(" ", linesa[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			write("-", linesa[i])
+			changed = true
+			i++
+		default:
+			write("+", linesb[j])
+			changed = true
+			j++
+		}
+	}
+	for ; i < len(linesa); i++ {
+		write("-", linesa[i])
+		changed = true
+	}
+	for ; j < len(linesb); j++ {
+		write("+", linesb[j])
+		changed = true
+	}
+
+	if !changed {
+		return ""
+	}
+
+	return sb.String()
+}
+
+// Normalize removes insignificant whitespace and trailing semicolons from css.
+// Whitespace inside strings is preserved, and other whitespace is collapsed
+// into a single space.
+func Normalize(css string) string {
+	var sb strings.Builder
+	sb.Grow(len(css))
+
+	var pendingSpace bool
+
+	for i := 0; i < len(css); i++ {
+		ch := css[i]
+		switch {
+		case isSpace(ch):
+			pendingSpace = true
+			continue
+		case ch == '"' || ch == '\'':
+			if pendingSpace {
+				writeSpace(&sb)
+				pendingSpace = false
+			}
+			j := i + 1
+			for ; j < len(css); j++ {
+				if css[j] == '\\' {
+					j++
+					continue
+				}
+				if css[j] == ch {
+					break
+				}
+			}
+			end := min(j+1, len(css))
+			sb.WriteString(css[i:end])
+			i = end - 1
+			continue
+		case isPunct(ch):
+			if ch == ':' && pendingSpace {
+				// Keep e.g. "div :hover" as is.
+				writeSpace(&sb)
+			}
+			if ch == '}' {
+				trimTrailingSemicolon(&sb)
+			}
+			sb.WriteByte(ch)
+		default:
+			if pendingSpace {
+				writeSpace(&sb)
+			}
+			sb.WriteByte(ch)
+		}
+		pendingSpace = false
+	}
+
+	return sb.String()
+}
+
+func writeSpace(sb *strings.Builder) {
+	s := sb.String()
+	if s == "" || isPunct(s[len(s)-1]) {
+		return
+	}
+	sb.WriteByte(' ')
+}
+
+func trimTrailingSemicolon(sb *strings.Builder) {
+	s := sb.String()
+	if strings.HasSuffix(s, ";") {
+		sb.Reset()
+		sb.WriteString(s[:len(s)-1])
+	}
+}
+
+// statements splits normalized CSS into one statement per line.
+func statements(css string) []string {
+	var (
+		lines []string
+		start int
+		quote byte
+	)
+	for i := 0; i < len(css); i++ {
+		ch := css[i]
+		if quote != 0 {
+			if ch == '\\' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch ch {
+		case '"', '\'':
+			quote = ch
+		case '{', '}', ';':
+			lines = append(lines, css[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(css) {
+		lines = append(lines, css[start:])
+	}
+	return lines
+}
+
+func isSpace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' || ch == '\f'
+}
+
+// isPunct reports whether whitespace around ch is insignificant.
+// For ':' this is only true for the whitespace after it.
+func isPunct(ch byte) bool {
+	switch ch {
+	case '{', '}', ';', ':', ',', '>':
+		return true
+	}
+	return false
+}
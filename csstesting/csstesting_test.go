@@ -0,0 +1,46 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package csstesting
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestCSSEqual(t *testing.T) {
+	c := qt.New(t)
+
+	expanded := `div p {
+  color: #ccc;
+  font-family: "Helvetica Neue", sans-serif;
+}
+
+a > b,
+c {
+  margin: 0 auto;
+}`
+	compressed := `div p{color:#ccc;font-family:"Helvetica Neue",sans-serif}a>b,c{margin:0 auto}`
+
+	c.Assert(CSSEqual(expanded, compressed), qt.IsTrue)
+	c.Assert(CSSEqual("div { color: red; }", "div{color:red}"), qt.IsTrue)
+	c.Assert(CSSEqual("div{color:red}", "div{color:blue}"), qt.IsFalse)
+	c.Assert(CSSEqual("div :hover{}", "div:hover{}"), qt.IsFalse)
+	c.Assert(CSSEqual(`a::before{content:"a  b"}`, `a::before { content: "a b" }`), qt.IsFalse)
+	c.Assert(CSSEqual("div { margin: 0   auto }", "div{margin:0 auto}"), qt.IsTrue)
+}
+
+func TestNormalize(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(Normalize("div p {\n  color: #ccc;\n}\n"), qt.Equals, "div p{color:#ccc}")
+	c.Assert(Normalize(`a::before { content: "x ; }" ; }`), qt.Equals, `a::before{content:"x ; }"}`)
+}
+
+func TestDiffCSS(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(DiffCSS("div { color: red; }", "div{color:red}"), qt.Equals, "")
+	c.Assert(DiffCSS("div { color: red; margin: 0; }", "div{color:blue;margin:0}"), qt.Equals, " div{\n-color:red;\n+color:blue;\n margin:0}\n")
+}
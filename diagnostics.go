@@ -0,0 +1,100 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"encoding/json"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+)
+
+// Diagnostic severities.
+const (
+	SeverityError       = "error"
+	SeverityWarning     = "warning"
+	SeverityDeprecation = "deprecation"
+	SeverityDebug       = "debug"
+)
+
+// Diagnostic is a compile error or a log event in a stable form
+// suitable for tools, e.g. editors and CI.
+type Diagnostic struct {
+	// The URL of the file, empty if unknown.
+	File string `json:"file"`
+
+	// 1-based line and column, 0 if unknown.
+	Line   int `json:"line"`
+	Column int `json:"column"`
+
+	// One of the Severity constants.
+	Severity string `json:"severity"`
+
+	Message string `json:"message"`
+
+	// The source text around the location, if available.
+	Snippet string `json:"snippet"`
+}
+
+func newLogEventDiagnostic(e *embeddedsass.OutboundMessage_LogEvent, file string) Diagnostic {
+	d := Diagnostic{Message: e.GetMessage()}
+
+	switch e.GetType() {
+	case embeddedsass.LogEventType_DEPRECATION_WARNING:
+		d.Severity = SeverityDeprecation
+	case embeddedsass.LogEventType_DEBUG:
+		d.Severity = SeverityDebug
+	default:
+		d.Severity = SeverityWarning
+	}
+
+	if span := e.GetSpan(); span != nil {
+		d.File = file
+		d.Line = int(span.GetStart().GetLine()) + 1
+		d.Column = int(span.GetStart().GetColumn()) + 1
+		d.Snippet = span.GetContext()
+		if d.Snippet == "" {
+			d.Snippet = span.GetText()
+		}
+	}
+
+	return d
+}
+
+// Diagnostic returns e as a Diagnostic.
+func (e SassError) Diagnostic() Diagnostic {
+	d := Diagnostic{
+		File:     e.Span.Url,
+		Severity: SeverityError,
+		Message:  e.Message,
+		Snippet:  e.Span.Context,
+	}
+	if d.Snippet == "" {
+		d.Snippet = e.Span.Text
+	}
+	if d.File != "" || d.Snippet != "" {
+		d.Line = e.Span.Start.Line + 1
+		d.Column = e.Span.Start.Column + 1
+	}
+	return d
+}
+
+// JSON returns e as a JSON encoded Diagnostic.
+func (e SassError) JSON() ([]byte, error) {
+	return json.Marshal(e.Diagnostic())
+}
+
+// Diagnostics returns the warnings and other log events from the compilation.
+func (r Result) Diagnostics() []Diagnostic {
+	return r.diagnostics
+}
+
+// DiagnosticsJSON returns the warnings and other log events from the
+// compilation as a JSON encoded array of Diagnostic.
+func (r Result) DiagnosticsJSON() ([]byte, error) {
+	diagnostics := r.diagnostics
+	if diagnostics == nil {
+		diagnostics = []Diagnostic{}
+	}
+	return json.Marshal(diagnostics)
+}
@@ -0,0 +1,338 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSassErrorJSON(t *testing.T) {
+	c := qt.New(t)
+
+	var sassErr SassError
+	sassErr.Message = "Undefined variable."
+	sassErr.Span.Url = "file:///my/main.scss"
+	sassErr.Span.Context = "a { color: $x; }"
+	sassErr.Span.Start.Line = 2
+	sassErr.Span.Start.Column = 11
+
+	b, err := sassErr.JSON()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(b), qt.Equals, `{"file":"file:///my/main.scss","line":3,"column":12,"severity":"error","message":"Undefined variable.","snippet":"a { color: $x; }"}`)
+
+	b, err = SassError{Message: "boom"}.JSON()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(b), qt.Equals, `{"file":"","line":0,"column":0,"severity":"error","message":"boom","snippet":""}`)
+}
+
+func TestResultDiagnosticsJSON(t *testing.T) {
+	c := qt.New(t)
+
+	events := func(_ uint32, req *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		if req.GetString_().GetSource() == "" {
+			return nil
+		}
+		return []*embeddedsass.OutboundMessage_LogEvent{
+			{
+				Type:    embeddedsass.LogEventType_WARNING,
+				Message: "foo",
+				Span: &embeddedsass.SourceSpan{
+					Url:     "data:;charset=utf-8,a",
+					Context: "@warn 'foo';",
+					Start:   &embeddedsass.SourceSpan_SourceLocation{Line: 1, Column: 2},
+				},
+			},
+			{Type: embeddedsass.LogEventType_DEPRECATION_WARNING, Message: "bar"},
+		}
+	}
+	handler := withLogEvents(events, echoCompileHandler)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: "@warn 'foo';", URL: "file:///my/main.scss"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.Diagnostics(), qt.DeepEquals, []Diagnostic{
+		{File: "file:///my/main.scss", Line: 2, Column: 3, Severity: SeverityWarning, Message: "foo", Snippet: "@warn 'foo';"},
+		{Severity: SeverityDeprecation, Message: "bar"},
+	})
+
+	b, err := result.DiagnosticsJSON()
+	c.Assert(err, qt.IsNil)
+	var diagnostics []map[string]any
+	c.Assert(json.Unmarshal(b, &diagnostics), qt.IsNil)
+	c.Assert(diagnostics, qt.HasLen, 2)
+	for _, field := range []string{"file", "line", "column", "severity", "message", "snippet"} {
+		c.Assert(diagnostics[0][field], qt.Not(qt.IsNil), qt.Commentf(field))
+	}
+
+	result, err = transpiler.Execute(Args{})
+	c.Assert(err, qt.IsNil)
+	b, err = result.DiagnosticsJSON()
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(b), qt.Equals, "[]")
+}
+
+func TestLogEvents(t *testing.T) {
+	c := qt.New(t)
+
+	deprecationType := "import"
+	warnings := func(uint32, *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		return []*embeddedsass.OutboundMessage_LogEvent{
+			{Type: embeddedsass.LogEventType_DEBUG, Message: "debug"},
+			{Type: embeddedsass.LogEventType_DEPRECATION_WARNING, Message: "deprecated", DeprecationType: &deprecationType},
+			{Type: embeddedsass.LogEventType_WARNING, Message: "warn"},
+		}
+	}
+	// Warns about the future deprecations opted into.
+	futureDeprecations := func(_ uint32, req *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		var events []*embeddedsass.OutboundMessage_LogEvent
+		for _, id := range req.GetFutureDeprecation() {
+			events = append(events, &embeddedsass.OutboundMessage_LogEvent{Type: embeddedsass.LogEventType_DEPRECATION_WARNING, Message: "deprecated", DeprecationType: &id})
+		}
+		return events
+	}
+
+	deprecated := LogEvent{CompilationID: 1, Type: LogEventTypeDeprecated, DeprecationType: "import", Message: "deprecated"}
+	warn := LogEvent{CompilationID: 1, Type: LogEventTypeWarning, Message: "warn"}
+	debug := LogEvent{CompilationID: 1, Type: LogEventTypeDebug, Message: "debug"}
+
+	for _, test := range []struct {
+		name        string
+		events      func(uint32, *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent
+		debug       bool
+		args        Args
+		expect      []LogEvent
+		expectDebug []LogEvent
+		diagnostics int
+	}{
+		{"All", warnings, false, Args{}, []LogEvent{debug, deprecated, warn}, nil, 3},
+		{"DebugHandler", warnings, true, Args{}, []LogEvent{deprecated, warn}, []LogEvent{debug}, 3},
+		{"QuietDeprecations", warnings, false, Args{QuietDeprecations: true}, []LogEvent{debug, warn}, nil, 2},
+		{"No FutureDeprecations", futureDeprecations, false, Args{}, nil, nil, 0},
+		{"FutureDeprecations", futureDeprecations, false, Args{FutureDeprecations: []string{"import"}}, []LogEvent{deprecated}, nil, 1},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			var events, debugEvents []LogEvent
+			opts := Options{LogEventHandler: func(e LogEvent) { events = append(events, e) }}
+			if test.debug {
+				opts.DebugHandler = func(e LogEvent) { debugEvents = append(debugEvents, e) }
+			}
+			transpiler, _ := newFakeConnTranspiler(c, opts, withLogEvents(test.events, echoCompileHandler))
+			defer transpiler.Close()
+
+			args := test.args
+			args.Source = "a{b:c}"
+			result, err := transpiler.Execute(args)
+			c.Assert(err, qt.IsNil)
+			c.Assert(events, qt.DeepEquals, test.expect)
+			c.Assert(debugEvents, qt.DeepEquals, test.expectDebug)
+			c.Assert(result.Diagnostics(), qt.HasLen, test.diagnostics)
+		})
+	}
+}
+
+func TestLogEventDataURL(t *testing.T) {
+	c := qt.New(t)
+
+	source := "@warn 'foo';"
+	dataURL := "data:;charset=utf-8," + url.QueryEscape(strings.Repeat(source, 100))
+	events := func(uint32, *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		return []*embeddedsass.OutboundMessage_LogEvent{
+			{
+				Type:    embeddedsass.LogEventType_WARNING,
+				Message: "foo",
+				Span: &embeddedsass.SourceSpan{
+					Url:   dataURL,
+					Start: &embeddedsass.SourceSpan_SourceLocation{Line: 1, Column: 2},
+				},
+			},
+		}
+	}
+
+	var logged []LogEvent
+	transpiler, _ := newFakeConnTranspiler(c, Options{LogEventHandler: func(e LogEvent) { logged = append(logged, e) }}, withLogEvents(events, echoCompileHandler))
+	defer transpiler.Close()
+
+	_, err := transpiler.Execute(Args{Source: source})
+	c.Assert(err, qt.IsNil)
+	_, err = transpiler.Execute(Args{Source: source, URL: "file:///a/main.scss"})
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(logged, qt.HasLen, 2)
+	for _, e := range logged {
+		c.Assert(e.Message, qt.Equals, "foo")
+		c.Assert(e.Span.URL, qt.Equals, dataURL)
+		c.Assert(e.Span.Start, qt.Equals, SourceLocation{Line: 1, Column: 2})
+	}
+}
+
+func TestLogEventCompilationID(t *testing.T) {
+	c := qt.New(t)
+
+	// Sends the source as three warnings.
+	events := func(_ uint32, req *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		e := &embeddedsass.OutboundMessage_LogEvent{Type: embeddedsass.LogEventType_WARNING, Message: req.GetString_().GetSource()}
+		return []*embeddedsass.OutboundMessage_LogEvent{e, e, e}
+	}
+
+	var mu sync.Mutex
+	messages := make(map[uint32][]string)
+	opts := Options{
+		LogEventHandler: func(e LogEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			messages[e.CompilationID] = append(messages[e.CompilationID], e.Message)
+		},
+	}
+	transpiler, _ := newFakeConnTranspiler(c, opts, withLogEvents(events, echoCompileHandler))
+	defer transpiler.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := transpiler.Execute(Args{Source: fmt.Sprintf("a%d{b:c}", i)})
+			c.Check(err, qt.IsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	c.Assert(messages, qt.HasLen, 2)
+	for id, m := range messages {
+		c.Assert(id, qt.Not(qt.Equals), uint32(0))
+		c.Assert(m, qt.HasLen, 3)
+		c.Assert(m[1], qt.Equals, m[0])
+		c.Assert(m[2], qt.Equals, m[0])
+	}
+}
+
+func TestRecentLogs(t *testing.T) {
+	c := qt.New(t)
+
+	// Sends three log events per compilation.
+	events := func(compilationID uint32, _ *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		var events []*embeddedsass.OutboundMessage_LogEvent
+		for i := 1; i <= 3; i++ {
+			events = append(events, &embeddedsass.OutboundMessage_LogEvent{Type: embeddedsass.LogEventType_WARNING, Message: fmt.Sprintf("%d.%d", compilationID, i)})
+		}
+		return events
+	}
+	handler := withLogEvents(events, echoCompileHandler)
+
+	messages := func(events []LogEvent) []string {
+		var s []string
+		for _, e := range events {
+			s = append(s, e.Message)
+		}
+		return s
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{LogHistorySize: 4}, handler)
+	defer transpiler.Close()
+
+	c.Assert(transpiler.RecentLogs(), qt.HasLen, 0)
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(messages(transpiler.RecentLogs()), qt.DeepEquals, []string{"1.1", "1.2", "1.3"})
+	for i := 0; i < 2; i++ {
+		_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+		c.Assert(err, qt.IsNil)
+	}
+	c.Assert(messages(transpiler.RecentLogs()), qt.DeepEquals, []string{"2.3", "3.1", "3.2", "3.3"})
+
+	transpiler, _ = newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+	_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(transpiler.RecentLogs(), qt.HasLen, 0)
+}
+
+func TestSassError(t *testing.T) {
+	c := qt.New(t)
+
+	const rawURL = "file:///a/../b.scss?v=1&x=%20"
+	start := &embeddedsass.SourceSpan_SourceLocation{Line: 2, Column: 6}
+
+	// Fails with the span in the source's entry.
+	spans := map[string]*embeddedsass.SourceSpan{
+		"url":     {Url: rawURL, Start: start},
+		"no url":  {Start: start},
+		"no span": nil,
+	}
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if req := msg.GetCompileRequest(); req != nil {
+			send(compilationID, compileFailure("boom", spans[req.GetString_().GetSource()]))
+		}
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	for _, test := range []struct {
+		source string
+		rawURL string
+		expect string
+	}{
+		{"url", rawURL, `/b.scss?v=1&x=%20:3:7: boom`},
+		{"no url", "", `3:7: boom`},
+		{"no span", "", `boom`},
+	} {
+		_, err := transpiler.Execute(Args{Source: test.source})
+		var sassErr SassError
+		c.Assert(errors.As(err, &sassErr), qt.IsTrue)
+		c.Assert(sassErr.RawURL, qt.Equals, test.rawURL)
+		if spans[test.source] != nil {
+			c.Assert(sassErr.Span.Start, qt.Equals, SourceLocation{Line: 2, Column: 6})
+		}
+		c.Assert(sassErr.Error(), qt.Equals, test.expect, qt.Commentf(test.source))
+	}
+}
+
+func TestSassErrorPreludeAdjusted(t *testing.T) {
+	c := qt.New(t)
+
+	// Fails at the start of the second line of the source.
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		req := msg.GetCompileRequest()
+		if req == nil {
+			return
+		}
+		offset := strings.Index(req.GetString_().GetSource(), "\n") + 1
+		send(compilationID, compileFailure("boom", &embeddedsass.SourceSpan{
+			Url:   req.GetString_().GetUrl(),
+			Start: &embeddedsass.SourceSpan_SourceLocation{Offset: uint32(offset), Line: 1},
+			End:   &embeddedsass.SourceSpan_SourceLocation{Offset: uint32(offset + 1), Line: 1, Column: 1},
+		}))
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	for _, variables := range []map[string]string{nil, {"a": "b"}} {
+		_, err := transpiler.Execute(Args{Source: "a{}\nb{}", URL: "file:///a.scss", Variables: variables})
+		var sassErr SassError
+		c.Assert(errors.As(err, &sassErr), qt.IsTrue)
+		if variables == nil {
+			c.Assert(sassErr.Span.Start.Line, qt.Equals, 1)
+			c.Assert(sassErr.Span.Start.Offset, qt.Equals, 4)
+		} else {
+			// The error is in the first line of the user's source.
+			c.Assert(sassErr.Span.Start.Line, qt.Equals, 0)
+			c.Assert(sassErr.Span.Start.Offset, qt.Equals, 0)
+			c.Assert(sassErr.Span.End.Offset, qt.Equals, 1)
+			c.Assert(sassErr.Span.End.Column, qt.Equals, 1)
+		}
+	}
+}
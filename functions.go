@@ -0,0 +1,225 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+)
+
+// CustomFunction is a Sass function implemented in Go.
+// The arguments are in the order of the parameters in the function's
+// signature, with any default values applied by Dart Sass.
+// A rest parameter, e.g. $args..., is passed as a SassList.
+type CustomFunction func(args []Value) (Value, error)
+
+// Value is a Sass value passed to or returned from a CustomFunction,
+// one of SassString, SassNumber, SassBool, SassNull and SassList.
+type Value interface {
+	sassValue()
+}
+
+// SassString is a Sass string.
+type SassString struct {
+	Text   string
+	Quoted bool
+}
+
+// SassNumber is a Sass number, e.g. 10px or 1.5.
+type SassNumber struct {
+	Value float64
+
+	// The units, e.g. ["px"] for 10px and ["px"], ["s"] for 10px/s.
+	Numerators   []string
+	Denominators []string
+}
+
+// SassBool is a Sass boolean.
+type SassBool bool
+
+// SassNull is the Sass null value.
+type SassNull struct{}
+
+// SassList is a Sass list.
+type SassList struct {
+	Values []Value
+
+	// One of ",", " " and "/", empty if undecided.
+	Separator string
+
+	// Whether the list has square brackets.
+	Brackets bool
+}
+
+func (SassString) sassValue() {}
+func (SassNumber) sassValue() {}
+func (SassBool) sassValue()   {}
+func (SassNull) sassValue()   {}
+func (SassList) sassValue()   {}
+
+var listSeparators = map[embeddedsass.ListSeparator]string{
+	embeddedsass.ListSeparator_COMMA: ",",
+	embeddedsass.ListSeparator_SPACE: " ",
+	embeddedsass.ListSeparator_SLASH: "/",
+}
+
+// functionName returns the name in a function signature,
+// e.g. "pow" for "pow($base, $exp)".
+func functionName(signature string) (string, error) {
+	i := strings.IndexByte(signature, '(')
+	if i == -1 || !strings.HasSuffix(strings.TrimSpace(signature), ")") {
+		return "", &InvalidOptionError{Field: "Functions", Value: signature}
+	}
+	name := strings.TrimSpace(signature[:i])
+	if !isVariableName(name) {
+		return "", &InvalidOptionError{Field: "Functions", Value: signature}
+	}
+	return name, nil
+}
+
+// initFunctions sets the functions to send with the compile request,
+// keyed by name, and their signatures, sorted.
+// The functions in Args.Functions replace those in Options.Functions
+// with the same name, but two signatures with the same name in one of
+// them is an error.
+func (args *Args) initFunctions(opts Options) error {
+	if len(opts.Functions) == 0 && len(args.Functions) == 0 {
+		return nil
+	}
+	args.functions = make(map[string]CustomFunction, len(opts.Functions)+len(args.Functions))
+	signatures := make(map[string]string)
+	for _, set := range []struct {
+		field     string
+		functions map[string]CustomFunction
+	}{
+		{"Options.Functions", opts.Functions},
+		{"Args.Functions", args.Functions},
+	} {
+		seen := make(map[string]string)
+		for signature, fn := range set.functions {
+			name, err := functionName(signature)
+			if err != nil {
+				return err
+			}
+			if other, found := seen[name]; found {
+				a, b := other, signature
+				if a > b {
+					a, b = b, a
+				}
+				return fmt.Errorf("duplicate function %q in %s: %q and %q", name, set.field, a, b)
+			}
+			seen[name] = signature
+			args.functions[name] = fn
+			signatures[name] = signature
+		}
+	}
+	for _, signature := range signatures {
+		args.sassFunctions = append(args.sassFunctions, signature)
+	}
+	sort.Strings(args.sassFunctions)
+	return nil
+}
+
+// callFunction invokes the function for req and returns its result.
+func (call *call) callFunction(req *embeddedsass.OutboundMessage_FunctionCallRequest) (*embeddedsass.Value, error) {
+	name := req.GetName()
+	if name == "" {
+		return nil, fmt.Errorf("function with ID %d not found", req.GetFunctionId())
+	}
+	var fn CustomFunction
+	if call != nil {
+		fn = call.functions[name]
+	}
+	if fn == nil {
+		return nil, fmt.Errorf("function %q not found", name)
+	}
+
+	args := make([]Value, len(req.GetArguments()))
+	for i, arg := range req.GetArguments() {
+		v, err := fromProtoValue(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		args[i] = v
+	}
+
+	result, err := fn(args)
+	if err != nil {
+		return nil, err
+	}
+	return toProtoValue(result)
+}
+
+func fromProtoValue(v *embeddedsass.Value) (Value, error) {
+	switch v := v.GetValue().(type) {
+	case *embeddedsass.Value_String_:
+		return SassString{Text: v.String_.GetText(), Quoted: v.String_.GetQuoted()}, nil
+	case *embeddedsass.Value_Number_:
+		return SassNumber{Value: v.Number.GetValue(), Numerators: v.Number.GetNumerators(), Denominators: v.Number.GetDenominators()}, nil
+	case *embeddedsass.Value_Singleton:
+		switch v.Singleton {
+		case embeddedsass.SingletonValue_TRUE:
+			return SassBool(true), nil
+		case embeddedsass.SingletonValue_FALSE:
+			return SassBool(false), nil
+		default:
+			return SassNull{}, nil
+		}
+	case *embeddedsass.Value_List_:
+		return fromProtoList(v.List.GetContents(), v.List.GetSeparator(), v.List.GetHasBrackets())
+	case *embeddedsass.Value_ArgumentList_:
+		return fromProtoList(v.ArgumentList.GetContents(), v.ArgumentList.GetSeparator(), false)
+	default:
+		return nil, fmt.Errorf("unsupported Sass value %T", v)
+	}
+}
+
+func fromProtoList(contents []*embeddedsass.Value, separator embeddedsass.ListSeparator, brackets bool) (Value, error) {
+	list := SassList{Separator: listSeparators[separator], Brackets: brackets}
+	for _, c := range contents {
+		v, err := fromProtoValue(c)
+		if err != nil {
+			return nil, err
+		}
+		list.Values = append(list.Values, v)
+	}
+	return list, nil
+}
+
+func toProtoValue(v Value) (*embeddedsass.Value, error) {
+	switch v := v.(type) {
+	case SassString:
+		return &embeddedsass.Value{Value: &embeddedsass.Value_String_{String_: &embeddedsass.Value_String{Text: v.Text, Quoted: v.Quoted}}}, nil
+	case SassNumber:
+		return &embeddedsass.Value{Value: &embeddedsass.Value_Number_{Number: &embeddedsass.Value_Number{Value: v.Value, Numerators: v.Numerators, Denominators: v.Denominators}}}, nil
+	case SassBool:
+		singleton := embeddedsass.SingletonValue_FALSE
+		if v {
+			singleton = embeddedsass.SingletonValue_TRUE
+		}
+		return &embeddedsass.Value{Value: &embeddedsass.Value_Singleton{Singleton: singleton}}, nil
+	case SassNull, nil:
+		return &embeddedsass.Value{Value: &embeddedsass.Value_Singleton{Singleton: embeddedsass.SingletonValue_NULL}}, nil
+	case SassList:
+		list := &embeddedsass.Value_List{Separator: embeddedsass.ListSeparator_UNDECIDED, HasBrackets: v.Brackets}
+		for sep, s := range listSeparators {
+			if s == v.Separator {
+				list.Separator = sep
+			}
+		}
+		for _, c := range v.Values {
+			pv, err := toProtoValue(c)
+			if err != nil {
+				return nil, err
+			}
+			list.Contents = append(list.Contents, pv)
+		}
+		return &embeddedsass.Value{Value: &embeddedsass.Value_List_{List: list}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported Sass value %T", v)
+	}
+}
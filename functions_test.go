@@ -0,0 +1,194 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"testing"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFunctionName(t *testing.T) {
+	c := qt.New(t)
+
+	for _, signature := range []string{"pow($base, $exp)", "pow()", " pow ($base...) "} {
+		name, err := functionName(signature)
+		c.Assert(err, qt.IsNil)
+		c.Assert(name, qt.Equals, "pow")
+	}
+
+	for _, signature := range []string{"pow", "($a)", "1pow($a)", "pow($a"} {
+		_, err := functionName(signature)
+		c.Assert(err, qt.ErrorMatches, `invalid Functions ".*"`)
+	}
+}
+
+func TestInitFunctions(t *testing.T) {
+	c := qt.New(t)
+
+	fn := func(args []Value) (Value, error) { return SassNull{}, nil }
+
+	args := Args{Functions: map[string]CustomFunction{"a($x)": fn, "b()": fn}}
+	c.Assert(args.initFunctions(Options{Functions: map[string]CustomFunction{"a()": fn, "c()": fn}}), qt.IsNil)
+	c.Assert(args.sassFunctions, qt.DeepEquals, []string{"a($x)", "b()", "c()"})
+	c.Assert(args.functions, qt.HasLen, 3)
+
+	args = Args{Functions: map[string]CustomFunction{"pow($a)": fn, "pow($a, $b)": fn}}
+	c.Assert(args.initFunctions(Options{}), qt.ErrorMatches, `duplicate function "pow" in Args.Functions: "pow\(\$a\)" and "pow\(\$a, \$b\)"`)
+
+	args = Args{}
+	c.Assert(args.initFunctions(Options{Functions: map[string]CustomFunction{"pow($a)": fn, " pow ($a)": fn}}), qt.ErrorMatches, `duplicate function "pow" in Options.Functions.*`)
+}
+
+func TestProtoValueRoundTrip(t *testing.T) {
+	c := qt.New(t)
+
+	for _, v := range []Value{
+		SassString{Text: "a", Quoted: true},
+		SassNumber{Value: 10, Numerators: []string{"px"}, Denominators: []string{"s"}},
+		SassBool(true),
+		SassBool(false),
+		SassNull{},
+		SassList{Values: []Value{SassNumber{Value: 1}, SassString{Text: "b"}}, Separator: ",", Brackets: true},
+		SassList{Values: []Value{SassNull{}}},
+	} {
+		pv, err := toProtoValue(v)
+		c.Assert(err, qt.IsNil)
+		got, err := fromProtoValue(pv)
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.DeepEquals, v)
+	}
+
+	pv, err := toProtoValue(nil)
+	c.Assert(err, qt.IsNil)
+	got, err := fromProtoValue(pv)
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Equals, SassNull{})
+}
+
+// newFunctionCallHandler returns a handler that for each compile request
+// calls the function name with args, responding with the result as CSS.
+func newFunctionCallHandler(name string, args ...*embeddedsass.Value) fakeHandler {
+	return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			send(compilationID, &embeddedsass.OutboundMessage{
+				Message: &embeddedsass.OutboundMessage_FunctionCallRequest_{
+					FunctionCallRequest: &embeddedsass.OutboundMessage_FunctionCallRequest{
+						Id:         1,
+						Identifier: &embeddedsass.OutboundMessage_FunctionCallRequest_Name{Name: name},
+						Arguments:  args,
+					},
+				},
+			})
+		case *embeddedsass.InboundMessage_FunctionCallResponse_:
+			if e := m.FunctionCallResponse.GetError(); e != "" {
+				send(compilationID, compileFailure(e, nil))
+				return
+			}
+			send(compilationID, compileSuccess(fmt.Sprint(m.FunctionCallResponse.GetSuccess().GetNumber().GetValue()), ""))
+		}
+	}
+}
+
+func sassNumber(v float64) *embeddedsass.Value {
+	return &embeddedsass.Value{Value: &embeddedsass.Value_Number_{Number: &embeddedsass.Value_Number{Value: v}}}
+}
+
+func TestFunctions(t *testing.T) {
+	c := qt.New(t)
+
+	pow := func(args []Value) (Value, error) {
+		base, ok1 := args[0].(SassNumber)
+		exp, ok2 := args[1].(SassNumber)
+		if !ok1 || !ok2 {
+			return nil, errors.New("pow: expected numbers")
+		}
+		return SassNumber{Value: math.Pow(base.Value, exp.Value)}, nil
+	}
+	opts := Options{Functions: map[string]CustomFunction{"pow($base, $exp)": pow}}
+	sassString := &embeddedsass.Value{Value: &embeddedsass.Value_String_{String_: &embeddedsass.Value_String{Text: "a"}}}
+
+	for _, test := range []struct {
+		name    string
+		handler fakeHandler
+		expect  string
+		errRe   string
+	}{
+		{"Call", newFunctionCallHandler("pow", sassNumber(2), sassNumber(10)), "1024", ""},
+		{"Error", newFunctionCallHandler("pow", sassNumber(2), sassString), "", ".*pow: expected numbers"},
+		{"Not found", newFunctionCallHandler("nope"), "", `.*function "nope" not found`},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			var reqs compileRequests
+			transpiler, _ := newFakeConnTranspiler(c, opts, reqs.handler(test.handler))
+			defer transpiler.Close()
+
+			result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+			c.Assert(reqs.last().GetGlobalFunctions(), qt.DeepEquals, []string{"pow($base, $exp)"})
+			if test.errRe != "" {
+				c.Assert(err, qt.ErrorMatches, test.errRe)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(result.CSS, qt.Equals, test.expect)
+		})
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{Functions: map[string]CustomFunction{"pow": pow}}, echoCompileHandler)
+	defer transpiler.Close()
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, `invalid Functions "pow"`)
+}
+
+func TestArgsFunctions(t *testing.T) {
+	c := qt.New(t)
+
+	constant := func(v float64) CustomFunction {
+		return func(args []Value) (Value, error) {
+			return SassNumber{Value: v}, nil
+		}
+	}
+
+	var reqs compileRequests
+	handler := newFunctionCallHandler("f")
+	opts := Options{Functions: map[string]CustomFunction{"f()": constant(-1), "g($a)": constant(-2)}}
+	transpiler, _ := newFakeConnTranspiler(c, opts, reqs.handler(func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		// The pipes are unbuffered, so don't block the reads while
+		// the Transpiler sends responses for other compilations.
+		handler(compilationID, msg, func(compilationID uint32, msg *embeddedsass.OutboundMessage) {
+			go send(compilationID, msg)
+		})
+	}))
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: "a{b:f()}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "-1")
+
+	// Concurrent compilations with their own f must each get theirs.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(num int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				result, err := transpiler.Execute(Args{Source: "a{b:f()}", Functions: map[string]CustomFunction{"f($a: 0)": constant(float64(num))}})
+				c.Check(err, qt.IsNil)
+				c.Check(result.CSS, qt.Equals, fmt.Sprint(num))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	globalFunctions := reqs.all()
+	c.Assert(globalFunctions[0].GetGlobalFunctions(), qt.DeepEquals, []string{"f()", "g($a)"})
+	c.Assert(globalFunctions[1].GetGlobalFunctions(), qt.DeepEquals, []string{"f($a: 0)", "g($a)"})
+}
@@ -5,11 +5,11 @@ go 1.21
 require (
 	github.com/cli/safeexec v1.0.1
 	github.com/frankban/quicktest v1.14.2
+	github.com/google/go-cmp v0.5.7
 	google.golang.org/protobuf v1.35.2
 )
 
 require (
-	github.com/google/go-cmp v0.5.7 // indirect
 	github.com/kr/pretty v0.3.0 // indirect
 	github.com/kr/text v0.2.0 // indirect
 	github.com/rogpeppe/go-internal v1.6.1 // indirect
@@ -0,0 +1,117 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+// Package framing implements the message framing used in the Embedded Sass
+// protocol.
+//
+// Every message begins with a varint indicating the length in bytes of the
+// remaining message including the compilation ID, followed by the
+// compilation ID as a varint and then the protobuf encoded message.
+package framing
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrInvalidFrame is returned from ReadFrame when a frame header is malformed.
+var ErrInvalidFrame = errors.New("invalid frame")
+
+// ByteReader is the reader used by Reader.
+type ByteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Reader reads frames.
+type Reader struct {
+	r   ByteReader
+	buf []byte
+}
+
+// NewReader creates a new Reader reading from r.
+// If r does not implement io.ByteReader, it will be buffered.
+func NewReader(r io.Reader) *Reader {
+	br, ok := r.(ByteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{r: br}
+}
+
+// ReadFrame reads the next frame.
+// The returned payload is only valid until the next call to ReadFrame.
+func (r *Reader) ReadFrame() (compilationID uint32, payload []byte, err error) {
+	l, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if l == 0 || l > math.MaxInt32 {
+		return 0, nil, fmt.Errorf("%w: length %d", ErrInvalidFrame, l)
+	}
+
+	plen := int(l)
+	if len(r.buf) < plen {
+		r.buf = make([]byte, plen)
+	}
+	buf := r.buf[:plen]
+
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, nil, err
+	}
+
+	v, n := binary.Uvarint(buf)
+	if n <= 0 || v > math.MaxUint32 {
+		return 0, nil, fmt.Errorf("%w: bad compilation ID", ErrInvalidFrame)
+	}
+
+	return uint32(v), buf[n:], nil
+}
+
+// Reset releases the read buffer.
+func (r *Reader) Reset() {
+	r.buf = nil
+}
+
+// Writer writes frames.
+type Writer struct {
+	w      io.Writer
+	lenBuf []byte
+	idBuf  []byte
+}
+
+// NewWriter creates a new Writer writing to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		w:      w,
+		lenBuf: make([]byte, binary.MaxVarintLen64),
+		idBuf:  make([]byte, binary.MaxVarintLen64),
+	}
+}
+
+// WriteFrame writes payload as a frame with the given compilation ID.
+func (w *Writer) WriteFrame(compilationID uint32, payload []byte) error {
+	reqLen := uint64(len(payload))
+	compilationIDLen := binary.PutUvarint(w.idBuf, uint64(compilationID))
+	headerLen := binary.PutUvarint(w.lenBuf, reqLen+uint64(compilationIDLen))
+
+	if _, err := w.w.Write(w.lenBuf[:headerLen]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(w.idBuf[:compilationIDLen]); err != nil {
+		return err
+	}
+	if _, err := w.w.Write(payload); err != nil {
+		return fmt.Errorf("failed to write payload: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package framing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRoundtrip(t *testing.T) {
+	c := qt.New(t)
+
+	for _, id := range []uint32{0, 1, 127, 128, 16383, 16384, math.MaxUint32} {
+		for _, size := range []int{0, 1, 125, 126, 127, 128, 16381, 16382, 16383, 16384, 100000} {
+			var buf bytes.Buffer
+			payload := bytes.Repeat([]byte{'a'}, size)
+			c.Assert(NewWriter(&buf).WriteFrame(id, payload), qt.IsNil)
+
+			gotID, gotPayload, err := NewReader(&buf).ReadFrame()
+			c.Assert(err, qt.IsNil)
+			c.Assert(gotID, qt.Equals, id)
+			c.Assert(gotPayload, qt.DeepEquals, payload)
+			c.Assert(buf.Len(), qt.Equals, 0)
+		}
+	}
+}
+
+func TestVarintBoundaries(t *testing.T) {
+	c := qt.New(t)
+
+	header := func(id uint32, size int) []byte {
+		var buf bytes.Buffer
+		c.Assert(NewWriter(&buf).WriteFrame(id, make([]byte, size)), qt.IsNil)
+		return buf.Bytes()[:buf.Len()-size]
+	}
+
+	// Length 127 fits in one byte, 128 needs two.
+	c.Assert(header(1, 126), qt.DeepEquals, []byte{127, 1})
+	c.Assert(header(1, 127), qt.DeepEquals, []byte{0x80, 0x01, 1})
+	// Compilation ID 128 needs two bytes, which is included in the length.
+	c.Assert(header(128, 0), qt.DeepEquals, []byte{2, 0x80, 0x01})
+	c.Assert(header(math.MaxUint32, 0), qt.DeepEquals, []byte{5, 0xff, 0xff, 0xff, 0xff, 0x0f})
+}
+
+func TestMultipleFrames(t *testing.T) {
+	c := qt.New(t)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	c.Assert(w.WriteFrame(1, []byte("first")), qt.IsNil)
+	c.Assert(w.WriteFrame(2, []byte("second frame")), qt.IsNil)
+
+	r := NewReader(&buf)
+	id, payload, err := r.ReadFrame()
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, uint32(1))
+	c.Assert(string(payload), qt.Equals, "first")
+	id, payload, err = r.ReadFrame()
+	c.Assert(err, qt.IsNil)
+	c.Assert(id, qt.Equals, uint32(2))
+	c.Assert(string(payload), qt.Equals, "second frame")
+	_, _, err = r.ReadFrame()
+	c.Assert(err, qt.Equals, io.EOF)
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	c := qt.New(t)
+
+	read := func(b ...byte) error {
+		_, _, err := NewReader(bytes.NewReader(b)).ReadFrame()
+		return err
+	}
+
+	c.Assert(read(), qt.Equals, io.EOF)
+	// Truncated length varint.
+	c.Assert(read(0x80), qt.Equals, io.ErrUnexpectedEOF)
+	// The length must include the compilation ID.
+	c.Assert(errors.Is(read(0), ErrInvalidFrame), qt.IsTrue)
+	// Truncated payload.
+	c.Assert(read(5, 1, 'a'), qt.Equals, io.ErrUnexpectedEOF)
+	// Truncated compilation ID varint.
+	c.Assert(errors.Is(read(1, 0x80), ErrInvalidFrame), qt.IsTrue)
+	// Compilation ID overflowing uint32.
+	c.Assert(errors.Is(read(5, 0xff, 0xff, 0xff, 0xff, 0x1f), ErrInvalidFrame), qt.IsTrue)
+	// Oversized length.
+	c.Assert(errors.Is(read(0xff, 0xff, 0xff, 0xff, 0x0f), ErrInvalidFrame), qt.IsTrue)
+}
+
+type errWriter struct {
+	n int
+}
+
+func (w *errWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errors.New("write failed")
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestWriteFrameErrors(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(NewWriter(&errWriter{n: 0}).WriteFrame(1, []byte("a")), qt.ErrorMatches, "write failed")
+	c.Assert(NewWriter(&errWriter{n: 1}).WriteFrame(1, []byte("a")), qt.ErrorMatches, "write failed")
+	c.Assert(NewWriter(&errWriter{n: 2}).WriteFrame(1, []byte("a")), qt.ErrorMatches, "failed to write payload: write failed")
+}
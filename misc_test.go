@@ -18,3 +18,10 @@ func TestHasScheme(t *testing.T) {
 	c.Assert(hasScheme("123:foo"), qt.Equals, false)
 	c.Assert(hasScheme("foo"), qt.Equals, false)
 }
+
+func TestFileURL(t *testing.T) {
+	c := qt.New(t)
+
+	c.Assert(fileURL("/a/b/c.scss"), qt.Equals, "file:///a/b/c.scss")
+	c.Assert(fileURL("/a/my dir/c.scss"), qt.Equals, "file:///a/my%20dir/c.scss")
+}
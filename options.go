@@ -4,12 +4,18 @@
 package godartsass
 
 import (
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/bep/godartsass/v2/internal/embeddedsass"
 	"github.com/bep/godartsass/v2/internal/godartsasstesting"
@@ -31,12 +37,122 @@ type Options struct {
 	// on Execute.
 	Timeout time.Duration
 
+	// If set, the Dart Sass process will be killed and restarted when
+	// a call to Execute times out, to stop a runaway compilation from using
+	// resources. Note that this will fail all other pending calls with
+	// ErrRestarted.
+	KillOnTimeout bool
+
+	// The Dart Sass process may keep state between compilations, e.g.
+	// cached modules. If set, the process is restarted before each
+	// compilation but the first, and compilations run one at a time.
+	// This is slow and mostly useful in tests, e.g. to compile the same
+	// module with different configurations.
+	IsolatePerCompile bool
+
 	// LogEventHandler will, if set, receive log events from Dart Sass,
 	// e.g. @debug and @warn log statements.
 	LogEventHandler func(LogEvent)
 
+	// DebugHandler will, if set, receive the @debug log events instead of
+	// LogEventHandler.
+	DebugHandler func(LogEvent)
+
+	// If set, the last LogHistorySize log events, from all compilations,
+	// are kept and available from Transpiler.RecentLogs, e.g. for debugging
+	// a failed build.
+	LogHistorySize int
+
 	// If not set, will default to os.Stderr.
 	Stderr io.Writer
+
+	// Whether Dart Sass uses terminal colors in its formatted messages.
+	// Default is true if Stderr is a terminal.
+	// Note that SassError and LogEvent messages are never formatted.
+	AlertColor *bool
+
+	// Whether Dart Sass limits its formatted messages to ASCII,
+	// e.g. no Unicode box-drawing characters. Default is false.
+	AlertASCII *bool
+
+	// If set, Start waits for Dart Sass to respond to a version request,
+	// failing with an error including what Dart Sass wrote to stderr
+	// if the process fails to start, e.g. because of a missing dependency.
+	// This adds a round trip to Start.
+	VerifyStart bool
+
+	// The number of times to retry starting the Dart Sass process if it
+	// fails, e.g. because of a transient resource shortage on a busy machine.
+	// There will be a short and increasing wait between each attempt.
+	// This also applies to Restart.
+	StartRetries int
+
+	// If set, a leading UTF-8 byte order mark is removed from Source,
+	// VirtualFiles and the content loaded by custom import resolvers.
+	StripBOM bool
+
+	// If set, Source and the content of imports will be checked for
+	// invalid UTF-8 before being passed to Dart Sass, which will otherwise
+	// fail with an error that can be hard to make sense of.
+	ValidateUTF8 bool
+
+	// If set, it is an error for a custom import resolver to load
+	// empty content, which Dart Sass would otherwise silently compile
+	// as an empty stylesheet.
+	ErrorOnEmptyImport bool
+
+	// If set, Execute will fail early with an actionable error if Source
+	// loads other stylesheets, but no importers are configured in Args,
+	// instead of Dart Sass' less helpful "Can't find stylesheet" error.
+	// This is a textual check that may give false positives,
+	// e.g. for rules in comments.
+	StrictImports bool
+
+	// If set, the maximum total number of imports a single compilation may
+	// load through custom import resolvers, protecting against resolvers that
+	// keep generating new URLs. This counts every import, not the nesting depth.
+	MaxImports int
+
+	// OnResolverImportResolved will, if set, be called when a custom import
+	// resolver (Args.ImportResolver) has canonicalized a URL, which may be
+	// useful when debugging which file gets imported.
+	// This covers custom resolvers only: imports resolved through IncludePaths
+	// are handled by Dart Sass itself and will not trigger this.
+	OnResolverImportResolved func(requestedURL, canonicalURL string)
+
+	// OnImportContent will, if set, be called with the content loaded by
+	// a custom import resolver, and the content returned will be passed to
+	// Dart Sass instead. Returning an error fails the compilation.
+	OnImportContent func(url, content string) (string, error)
+
+	// OnModuleLoad will, if set, be called in load order when a custom
+	// import resolver has loaded a module, e.g. for a @use or @forward rule,
+	// with the URL as written in the rule and its canonical URL.
+	OnModuleLoad func(url, canonicalURL string)
+
+	// The number of times to retry a failed CanonicalizeURL or Load call
+	// to a custom import resolver, e.g. for network-backed resolvers with
+	// transient failures.
+	// There will be a wait of ImportRetryBackoff multiplied by the attempt
+	// number between each attempt. Note that this blocks the handling of
+	// all messages from Dart Sass, so keep the backoff short.
+	ImportRetries      int
+	ImportRetryBackoff time.Duration
+
+	// If set, inconsistent messages from Dart Sass, e.g. a canonicalize or
+	// import request for an unknown compilation or reusing the ID of a
+	// request not yet answered, fail all pending compilations, catching compiler or transport bugs
+	// early. By default Dart Sass gets an error response and carries on.
+	StrictProtocol bool
+
+	// Custom Sass functions available to all compilations, keyed by
+	// signature, e.g. "pow($base, $exp)".
+	// The functions are called from one goroutine per Transpiler, but
+	// different Transpilers sharing a function may call it concurrently.
+	Functions map[string]CustomFunction
+
+	// Used in tests.
+	testingStartErr func() error
 }
 
 // LogEvent is a type of log event from Dart Sass.
@@ -54,14 +170,37 @@ const (
 )
 
 type LogEvent struct {
+	// CompilationID identifies the compilation, i.e. the call to Execute,
+	// that triggered the event, which is useful when grouping events
+	// from concurrent compilations.
+	CompilationID uint32
+
 	// Type is the type of log event.
 	Type LogEventType
 
 	// DeprecationType is set if Type is LogEventTypeDeprecated.
 	DeprecationType string
 
-	// Message on the form url:line:col message.
+	// The message as reported by Dart Sass.
 	Message string
+
+	// The source location of the event, nil if not available,
+	// e.g. for @warn in Dart Sass' own modules.
+	Span *LogEventSpan
+}
+
+// LogEventSpan is the source location of a LogEvent.
+type LogEventSpan struct {
+	// The URL of the source file as reported by Dart Sass, e.g. a data: URL
+	// for Source without Args.URL, empty if unknown.
+	URL string
+
+	// 0-based.
+	Start SourceLocation
+	End   SourceLocation
+
+	// The source text around the span, if available.
+	Context string
 }
 
 func (opts *Options) init() error {
@@ -77,9 +216,29 @@ func (opts *Options) init() error {
 		opts.Stderr = os.Stderr
 	}
 
+	if opts.AlertColor == nil {
+		color := isTerminal(opts.Stderr)
+		opts.AlertColor = &color
+	}
+
+	if opts.AlertASCII == nil {
+		ascii := false
+		opts.AlertASCII = &ascii
+	}
+
 	return nil
 }
 
+// isTerminal reports whether w is a character device, e.g. a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 // ImportResolver allows custom import resolution.
 //
 // CanonicalizeURL should create a canonical version of the given URL if it's
@@ -94,6 +253,13 @@ func (opts *Options) init() error {
 // always refers to the same stylesheet.
 //
 // Load loads the canonicalized URL's content.
+//
+// Note that Dart Sass handles the built-in modules, e.g. "sass:math",
+// itself, so they cannot be overridden by an ImportResolver.
+//
+// Modules loaded dynamically with meta.load-css, with or without a $with
+// configuration, are resolved the same way as @use rules; the configuration
+// is applied by Dart Sass.
 type ImportResolver interface {
 	CanonicalizeURL(url string) (string, error)
 	Load(canonicalizedURL string) (Import, error)
@@ -110,8 +276,33 @@ type Import struct {
 // Args holds the arguments to Execute.
 type Args struct {
 	// The input source.
+	// An empty or whitespace-only Source compiles to empty CSS.
 	Source string
 
+	// The input source as bytes, used if Source is empty.
+	// The protocol sends the source as a string, so this is copied once
+	// when the compile request is created.
+	SourceBytes []byte
+
+	// The filename of the entry point, as an alternative to Source.
+	// Dart Sass then reads the file itself, resolving relative loads and
+	// the syntax from the filename.
+	// Source, SourceBytes, EntryImporter and Variables cannot be combined
+	// with Path, and URL and SourceSyntax are ignored.
+	Path string
+
+	// In-memory files keyed by path, e.g. {"main.scss": "...",
+	// "components/_button.scss": "..."}, compiled starting with the file
+	// at Entry, as an alternative to Source.
+	// Loads are resolved relative to the loading file the same way as
+	// Dart Sass does for files on disk, including partials and index files.
+	// Source, SourceBytes, Path and EntryImporter cannot be combined
+	// with VirtualFiles, and URL is ignored.
+	VirtualFiles map[string]string
+
+	// The path of the entry point in VirtualFiles, e.g. "main.scss".
+	Entry string
+
 	// The URL of the Source.
 	// Leave empty if it's unknown.
 	// Must include a scheme, e.g. 'file:///myproject/main.scss'
@@ -127,63 +318,244 @@ type Args struct {
 	// Default is EXPANDED.
 	OutputStyle OutputStyle
 
+	// Whether Dart Sass adds a @charset rule, or a byte order mark in
+	// compressed output, to CSS with non-ASCII characters.
+	// Default is true.
+	Charset *bool
+
 	// If enabled, a sourcemap will be generated and returned in Result.
 	EnableSourceMap bool
 
 	// If enabled, sources will be embedded in the generated source map.
 	SourceMapIncludeSources bool
 
+	// If set, the file: URLs in the sources of the source map are rewritten
+	// to paths relative to this directory, e.g. "a.scss" for
+	// file:///project/src/a.scss relative to /project/src.
+	// Other sources, e.g. data: URLs, are left as-is.
+	SourceMapRelativeTo string
+
 	// Custom resolver to use to resolve imports.
 	// If set, this will be the first in the resolver chain.
 	ImportResolver ImportResolver
 
+	// Additional custom resolvers, consulted in order after ImportResolver
+	// and before IncludePaths.
+	// This allows e.g. a file loaded by one resolver to load URLs with
+	// a scheme handled by another.
+	ImportResolvers []ImportResolver
+
+	// EntryImporter, if set, is used to resolve loads relative to the
+	// entry point, e.g. @use "./sibling" in Source.
+	// URL must then be set to a canonical URL recognized by EntryImporter.
+	// Note that it is not consulted for non-relative loads; set ImportResolver
+	// for that.
+	EntryImporter ImportResolver
+
 	// Additional file paths to uses to resolve imports.
+	// See also Transpiler.SetIncludePaths.
 	IncludePaths []string
 
+	// Variables to make available to Source, e.g. {"primary": "#333"},
+	// referenced as vars.$primary unless VariablesGlobal is set.
+	// Names are without the leading $. Values must be valid SassScript
+	// expressions and are not escaped or quoted, so quote values that should
+	// be strings, e.g. "\"Helvetica\"". Execute fails for a value that is
+	// empty, spans lines or has an unquoted ;, { or }.
+	// The variables are put in a generated module loaded with
+	// a @use rule prepended to Source on its own line, so line numbers in
+	// source maps for Source will be off by one. The span of a SassError
+	// in Source is adjusted to not include the prepended line.
+	// This is not supported for SourceSyntaxCSS.
+	Variables map[string]string
+
+	// If set, Variables are made available without a namespace,
+	// e.g. $primary.
+	VariablesGlobal bool
+
+	// Custom Sass functions for this compilation, keyed by signature,
+	// in addition to Options.Functions. A function here replaces one in
+	// Options.Functions with the same name.
+	Functions map[string]CustomFunction
+
 	// Deprecation IDs to silence, e.g. "import".
 	SilenceDeprecations []string
 
+	// Future deprecation IDs to opt into before Dart Sass enables them by
+	// default. They are then reported as log events with the ID as
+	// DeprecationType, like other deprecations.
+	FutureDeprecations []string
+
+	// Deprecation IDs to treat as errors, e.g. "import", failing the
+	// compilation with a SassError instead of logging a warning.
+	FatalDeprecations []string
+
+	// If set, the "import" deprecation is fatal, so any @import rule
+	// loading a Sass file, including in dependencies, fails the compilation.
+	// This is a shorthand for adding "import" to FatalDeprecations.
+	RequireUse bool
+
+	// If set, warnings from stylesheets loaded through IncludePaths or
+	// import resolvers are silenced, as --quiet-deps in the Dart Sass CLI.
+	SilenceDependencyDeprecations bool
+
+	// If set, deprecation warnings are not passed to the LogEventHandler
+	// nor included in Result.Diagnostics, while @warn and @debug messages are.
+	QuietDeprecations bool
+
+	// If set, all deprecation warnings are logged, not only the first
+	// few of each type, as --verbose in the Dart Sass CLI.
+	Verbose bool
+
+	// If set, no warnings or debug messages are logged,
+	// as --quiet in the Dart Sass CLI.
+	//
+	// Other CLI flags have an equivalent in Args, e.g. --style,
+	// --load-path and --embed-sources. Flags for the CLI's own file handling
+	// have none, e.g. --watch, --update, --stop-on-error, --error-css,
+	// --embed-source-map and --source-map-urls.
+	Quiet bool
+
+	// If enabled, loud comments (/*! ... */), which Dart Sass keeps even in
+	// compressed output, will be removed from the CSS.
+	// This is a post-processing step and only applies to OutputStyleCompressed.
+	// The source map is not updated, so with EnableSourceMap the columns
+	// after a removed comment will not match.
+	StripLoudComments bool
+
+	// If set, Execute will fail if the CSS output is larger than this
+	// number of bytes.
+	MaxOutputBytes int
+
+	// The number of spaces or tabs per indentation level in
+	// OutputStyleExpanded output, default 2 spaces or 1 tab.
+	// This is a post-processing step re-indenting the CSS from Dart Sass,
+	// so columns in the source map will not match. Lines inside multi-line
+	// comments and strings are not re-indented.
+	IndentWidth int
+
+	// Default is IndentTypeSpace.
+	IndentType IndentType
+
 	sassOutputStyle  embeddedsass.OutputStyle
 	sassSourceSyntax embeddedsass.Syntax
 
-	// Ordered list starting with options.ImportResolver, then IncludePaths.
+	// Ordered list starting with the Variables module, if any, then
+	// ImportResolver, ImportResolvers and IncludePaths.
 	sassImporters []*embeddedsass.InboundMessage_CompileRequest_Importer
 
+	// The importer for the entry point, if any.
+	sassEntryImporter *embeddedsass.InboundMessage_CompileRequest_Importer
+
+	// Prepended to Source, see Variables.
+	prelude string
+
+	// The custom resolvers for this compilation, the importer ID is the index + 1.
+	importResolvers []ImportResolver
+
+	// The custom functions keyed by name and their signatures.
+	functions     map[string]CustomFunction
+	sassFunctions []string
+
 	// Used in tests.
 	testingShouldPanicWhen godartsasstesting.PanicWhen
 }
 
-func (args *Args) init(seq uint32, opts Options) error {
+func (args *Args) init(opts Options) error {
+	if args.Source == "" && len(args.SourceBytes) > 0 {
+		args.Source = string(args.SourceBytes)
+	}
+	if args.Path != "" {
+		switch {
+		case args.Source != "":
+			return errors.New("Path and Source cannot both be set")
+		case args.EntryImporter != nil:
+			return errors.New("EntryImporter is not supported with Path")
+		case len(args.Variables) > 0:
+			return errors.New("Variables is not supported with Path")
+		}
+	}
+	if args.Entry != "" || len(args.VirtualFiles) > 0 {
+		if err := args.initVirtualFiles(); err != nil {
+			return err
+		}
+	}
+	if opts.StripBOM {
+		args.Source = strings.TrimPrefix(args.Source, utf8BOM)
+	}
 	if args.OutputStyle == "" {
 		args.OutputStyle = OutputStyleExpanded
 	}
 	if args.SourceSyntax == "" {
 		args.SourceSyntax = SourceSyntaxSCSS
 	}
+	if args.SourceSyntax == SourceSyntaxAuto {
+		args.SourceSyntax = sourceSyntaxFromURL(args.URL)
+	}
 
 	v, ok := embeddedsass.OutputStyle_value[string(args.OutputStyle)]
 	if !ok {
-		return fmt.Errorf("invalid OutputStyle %q", args.OutputStyle)
+		return &InvalidOptionError{Field: "OutputStyle", Value: string(args.OutputStyle)}
 	}
 	args.sassOutputStyle = embeddedsass.OutputStyle(v)
 
 	v, ok = embeddedsass.Syntax_value[string(args.SourceSyntax)]
 	if !ok {
-		return fmt.Errorf("invalid SourceSyntax %q", args.SourceSyntax)
+		return &InvalidOptionError{Field: "SourceSyntax", Value: string(args.SourceSyntax)}
 	}
 
 	args.sassSourceSyntax = embeddedsass.Syntax(v)
 
-	if args.ImportResolver != nil {
-		args.sassImporters = []*embeddedsass.InboundMessage_CompileRequest_Importer{
-			{
-				Importer: &embeddedsass.InboundMessage_CompileRequest_Importer_ImporterId{
-					ImporterId: seq,
-				},
-			},
+	if args.IndentWidth < 0 {
+		return &InvalidOptionError{Field: "IndentWidth", Value: strconv.Itoa(args.IndentWidth)}
+	}
+	switch args.IndentType {
+	case "", IndentTypeSpace, IndentTypeTab:
+	default:
+		return &InvalidOptionError{Field: "IndentType", Value: string(args.IndentType)}
+	}
+
+	if opts.ValidateUTF8 {
+		if err := validateUTF8("Source", args.Source); err != nil {
+			return err
+		}
+	}
+
+	if err := args.initFunctions(opts); err != nil {
+		return err
+	}
+
+	if opts.StrictImports && args.ImportResolver == nil && len(args.ImportResolvers) == 0 && args.EntryImporter == nil && len(args.IncludePaths) == 0 {
+		if u := findImport(args.Source); u != "" {
+			return fmt.Errorf("Source loads %q, but no importers are configured; set ImportResolver, ImportResolvers, EntryImporter or IncludePaths in Args", u)
 		}
 	}
 
+	if len(args.Variables) > 0 {
+		if args.SourceSyntax == SourceSyntaxCSS {
+			return fmt.Errorf("Variables is not supported for SourceSyntax %s", args.SourceSyntax)
+		}
+		r, err := newVariablesImportResolver(args.Variables)
+		if err != nil {
+			return err
+		}
+		args.sassImporters = append(args.sassImporters, args.addImportResolver(r))
+		args.prelude = variablesPrelude(args.SourceSyntax, args.VariablesGlobal)
+		args.Source = args.prelude + args.Source
+	}
+
+	if args.ImportResolver != nil {
+		args.sassImporters = append(args.sassImporters, args.addImportResolver(args.ImportResolver))
+	}
+
+	for _, r := range args.ImportResolvers {
+		args.sassImporters = append(args.sassImporters, args.addImportResolver(r))
+	}
+
+	if args.EntryImporter != nil {
+		args.sassEntryImporter = args.addImportResolver(args.EntryImporter)
+	}
+
 	if args.IncludePaths != nil {
 		for _, p := range args.IncludePaths {
 			args.sassImporters = append(args.sassImporters, &embeddedsass.InboundMessage_CompileRequest_Importer{Importer: &embeddedsass.InboundMessage_CompileRequest_Importer_Path{
@@ -195,12 +567,129 @@ func (args *Args) init(seq uint32, opts Options) error {
 	return nil
 }
 
+// initVirtualFiles sets up the entry point in VirtualFiles as Source,
+// with loads relative to it resolved from VirtualFiles.
+func (args *Args) initVirtualFiles() error {
+	switch {
+	case args.Entry == "":
+		return errors.New("VirtualFiles requires Entry")
+	case args.Source != "" || args.Path != "":
+		return errors.New("Source and Path cannot be combined with VirtualFiles")
+	case args.EntryImporter != nil:
+		return errors.New("EntryImporter is not supported with VirtualFiles")
+	}
+	r := newVirtualFilesImportResolver(args.VirtualFiles)
+	u, _ := r.CanonicalizeURL(virtualFilesScheme + ":" + args.Entry)
+	if u == "" {
+		return fmt.Errorf("Entry %q not found in VirtualFiles", args.Entry)
+	}
+	imp, err := r.Load(u)
+	if err != nil {
+		return err
+	}
+	args.Source = imp.Content
+	args.URL = u
+	args.EntryImporter = r
+	if args.SourceSyntax == "" {
+		args.SourceSyntax = imp.SourceSyntax
+	}
+	return nil
+}
+
+var importRe = regexp.MustCompile(`@(?:import|use|forward)\s+["']([^"']+)["']`)
+
+// findImport returns the first URL in s loaded with @import, @use or
+// @forward that needs an importer to resolve, or an empty string if none.
+// This is a cheap textual scan and may give false positives,
+// e.g. for rules in comments.
+func findImport(s string) string {
+	for _, m := range importRe.FindAllStringSubmatch(s, -1) {
+		u := m[1]
+		switch {
+		case strings.HasPrefix(u, "sass:"):
+			// Built-in module.
+		case strings.HasSuffix(u, ".css"), strings.HasPrefix(u, "http://"), strings.HasPrefix(u, "https://"), strings.HasPrefix(u, "//"):
+			// Plain CSS import.
+		default:
+			return u
+		}
+	}
+	return ""
+}
+
+// validateUTF8 returns an error identifying the byte offset of the first
+// invalid UTF-8 sequence in s, if any.
+func validateUTF8(name, s string) error {
+	if utf8.ValidString(s) {
+		return nil
+	}
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				return fmt.Errorf("invalid UTF-8 in %s at byte offset %d", name, i)
+			}
+		}
+	}
+	return nil
+}
+
+func (args *Args) addImportResolver(r ImportResolver) *embeddedsass.InboundMessage_CompileRequest_Importer {
+	args.importResolvers = append(args.importResolvers, r)
+	return &embeddedsass.InboundMessage_CompileRequest_Importer{
+		Importer: &embeddedsass.InboundMessage_CompileRequest_Importer_ImporterId{
+			ImporterId: uint32(len(args.importResolvers)),
+		},
+	}
+}
+
+const utf8BOM = "\ufeff"
+
+// indent returns one level of indentation as set by IndentWidth and IndentType.
+func (args *Args) indent() string {
+	width := args.IndentWidth
+	if args.IndentType == IndentTypeTab {
+		if width == 0 {
+			width = 1
+		}
+		return strings.Repeat("\t", width)
+	}
+	if width == 0 {
+		width = 2
+	}
+	return strings.Repeat(" ", width)
+}
+
+// InvalidOptionError is returned from Execute when an option in Args has
+// a value not supported by Dart Sass.
+type InvalidOptionError struct {
+	// The name of the field, e.g. "OutputStyle".
+	Field string
+
+	// The invalid value.
+	Value string
+}
+
+func (e *InvalidOptionError) Error() string {
+	switch e.Field {
+	case "OutputStyle":
+		// LibSass' NESTED and COMPACT styles end up here.
+		return fmt.Sprintf("invalid %s %q: Dart Sass only supports %s and %s", e.Field, e.Value, OutputStyleExpanded, OutputStyleCompressed)
+	case "SourceSyntax":
+		return fmt.Sprintf("invalid %s %q: must be one of %s, %s or %s", e.Field, e.Value, SourceSyntaxSCSS, SourceSyntaxSASS, SourceSyntaxCSS)
+	default:
+		return fmt.Sprintf("invalid %s %q", e.Field, e.Value)
+	}
+}
+
 type (
 	// OutputStyle defines the style of the generated CSS.
 	OutputStyle string
 
 	// SourceSyntax defines the syntax of the source passed in Execute.
 	SourceSyntax string
+
+	// IndentType defines the indentation character of expanded output.
+	IndentType string
 )
 
 const (
@@ -222,10 +711,23 @@ const (
 
 	// Regular CSS source syntax.
 	SourceSyntaxCSS SourceSyntax = "CSS"
+
+	// Detect the source syntax from the file extension in URL,
+	// defaults to SourceSyntaxSCSS.
+	SourceSyntaxAuto SourceSyntax = "AUTO"
+)
+
+const (
+	// Indent with spaces (default).
+	IndentTypeSpace IndentType = "space"
+
+	// Indent with tabs.
+	IndentTypeTab IndentType = "tab"
 )
 
 // ParseOutputStyle will convert s into OutputStyle.
-// Case insensitive, returns OutputStyleNested for unknown value.
+// Case insensitive, returns OutputStyleExpanded for unknown values,
+// including LibSass' "nested" and "compact".
 func ParseOutputStyle(s string) OutputStyle {
 	switch OutputStyle(strings.ToUpper(s)) {
 	case OutputStyleCompressed:
@@ -247,6 +749,24 @@ func ParseSourceSyntax(s string) SourceSyntax {
 		return SourceSyntaxSASS
 	case SourceSyntaxCSS:
 		return SourceSyntaxCSS
+	case SourceSyntaxAuto:
+		return SourceSyntaxAuto
+	default:
+		return SourceSyntaxSCSS
+	}
+}
+
+// sourceSyntaxFromURL returns the source syntax matching the file
+// extension in u, defaulting to SourceSyntaxSCSS.
+func sourceSyntaxFromURL(u string) SourceSyntax {
+	if pu, err := url.Parse(u); err == nil {
+		u = pu.Path
+	}
+	switch strings.ToLower(path.Ext(u)) {
+	case ".sass":
+		return SourceSyntaxSASS
+	case ".css":
+		return SourceSyntaxCSS
 	default:
 		return SourceSyntaxSCSS
 	}
@@ -4,8 +4,14 @@
 package godartsass
 
 import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
 	qt "github.com/frankban/quicktest"
 )
 
@@ -16,6 +22,8 @@ func TestParseOutputStyle(t *testing.T) {
 	c.Assert(ParseOutputStyle("ComPressed"), qt.Equals, OutputStyleCompressed)
 	c.Assert(ParseOutputStyle("expanded"), qt.Equals, OutputStyleExpanded)
 	c.Assert(ParseOutputStyle("foo"), qt.Equals, OutputStyleExpanded)
+	c.Assert(ParseOutputStyle("nested"), qt.Equals, OutputStyleExpanded)
+	c.Assert(ParseOutputStyle("compact"), qt.Equals, OutputStyleExpanded)
 }
 
 func TestParseSourceSyntax(t *testing.T) {
@@ -27,4 +35,336 @@ func TestParseSourceSyntax(t *testing.T) {
 	c.Assert(ParseSourceSyntax("sass"), qt.Equals, SourceSyntaxSASS)
 	c.Assert(ParseSourceSyntax("indented"), qt.Equals, SourceSyntaxSASS)
 	c.Assert(ParseSourceSyntax("foo"), qt.Equals, SourceSyntaxSCSS)
+	c.Assert(ParseSourceSyntax("auto"), qt.Equals, SourceSyntaxAuto)
+}
+
+func TestArgsInitValidateUTF8(t *testing.T) {
+	c := qt.New(t)
+
+	source := "a{content:\"\xc3\x28\"}"
+
+	args := Args{Source: source}
+	c.Assert(args.init(Options{}), qt.IsNil)
+
+	args = Args{Source: source}
+	c.Assert(args.init(Options{ValidateUTF8: true}), qt.ErrorMatches, `invalid UTF-8 in Source at byte offset 11`)
+
+	args = Args{Source: "a{content:\"æøå\"}"}
+	c.Assert(args.init(Options{ValidateUTF8: true}), qt.IsNil)
+}
+
+func TestArgsInitSourceSyntaxAuto(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		url    string
+		expect SourceSyntax
+	}{
+		{"file:///project/main.sass", SourceSyntaxSASS},
+		{"file:///project/main.scss", SourceSyntaxSCSS},
+		{"file:///project/main.css", SourceSyntaxCSS},
+		{"file:///project/MAIN.SASS", SourceSyntaxSASS},
+		{"file:///project/main.sass?foo=bar.css", SourceSyntaxSASS},
+		{"/project/main.css", SourceSyntaxCSS},
+		{"file:///project/main", SourceSyntaxSCSS},
+		{"", SourceSyntaxSCSS},
+	} {
+		args := Args{URL: test.url, SourceSyntax: SourceSyntaxAuto}
+		c.Assert(args.init(Options{}), qt.IsNil)
+		c.Assert(args.SourceSyntax, qt.Equals, test.expect, qt.Commentf(test.url))
+		c.Assert(args.sassSourceSyntax.String(), qt.Equals, string(test.expect))
+	}
+}
+
+func TestArgsInitInvalidOption(t *testing.T) {
+	c := qt.New(t)
+
+	var invalidErr *InvalidOptionError
+
+	args := Args{OutputStyle: "asdf"}
+	err := args.init(Options{})
+	c.Assert(err, qt.ErrorMatches, `invalid OutputStyle "asdf": Dart Sass only supports EXPANDED and COMPRESSED`)
+	c.Assert(errors.As(err, &invalidErr), qt.IsTrue)
+	c.Assert(invalidErr.Field, qt.Equals, "OutputStyle")
+	c.Assert(invalidErr.Value, qt.Equals, "asdf")
+
+	args = Args{SourceSyntax: "foo"}
+	err = args.init(Options{})
+	c.Assert(err, qt.ErrorMatches, `invalid SourceSyntax "foo": must be one of SCSS, INDENTED or CSS`)
+	c.Assert(errors.As(err, &invalidErr), qt.IsTrue)
+	c.Assert(invalidErr.Field, qt.Equals, "SourceSyntax")
+	c.Assert(invalidErr.Value, qt.Equals, "foo")
+}
+
+func TestArgsInitLibSassOutputStyles(t *testing.T) {
+	c := qt.New(t)
+
+	for _, style := range []OutputStyle{"NESTED", "COMPACT", "nested", "compact"} {
+		var invalidErr *InvalidOptionError
+		args := Args{OutputStyle: style}
+		err := args.init(Options{})
+		c.Assert(errors.As(err, &invalidErr), qt.IsTrue)
+		c.Assert(invalidErr.Field, qt.Equals, "OutputStyle")
+		c.Assert(invalidErr.Value, qt.Equals, string(style))
+		c.Assert(err, qt.ErrorMatches, `invalid OutputStyle ".*": Dart Sass only supports EXPANDED and COMPRESSED`)
+	}
+}
+
+type testResolver struct {
+	name string
+}
+
+func (r testResolver) CanonicalizeURL(url string) (string, error) {
+	return "", nil
+}
+
+func (r testResolver) Load(url string) (Import, error) {
+	return Import{}, nil
+}
+
+func TestArgsInitStrictImports(t *testing.T) {
+	c := qt.New(t)
+
+	source := `@use "sass:math"; @import "foo.css"; @import url(bar.css); @use 'colors';`
+
+	args := Args{Source: source}
+	c.Assert(args.init(Options{}), qt.IsNil)
+
+	args = Args{Source: source}
+	c.Assert(args.init(Options{StrictImports: true}), qt.ErrorMatches, `Source loads "colors", but no importers are configured; set ImportResolver, ImportResolvers, EntryImporter or IncludePaths in Args`)
+
+	args = Args{Source: source, IncludePaths: []string{"/foo"}}
+	c.Assert(args.init(Options{StrictImports: true}), qt.IsNil)
+
+	args = Args{Source: source, ImportResolver: testResolver{}}
+	c.Assert(args.init(Options{StrictImports: true}), qt.IsNil)
+
+	args = Args{Source: `@use "sass:math"; @import "https://example.org/foo";`}
+	c.Assert(args.init(Options{StrictImports: true}), qt.IsNil)
+}
+
+func TestArgsInitVariables(t *testing.T) {
+	c := qt.New(t)
+
+	args := Args{Source: "a{color:vars.$primary}", Variables: map[string]string{"primary": "#333", "font": `"Helvetica; Arial"`}}
+	c.Assert(args.init(Options{}), qt.IsNil)
+	c.Assert(args.Source, qt.Equals, "@use \"godartsass:variables\" as vars;\na{color:vars.$primary}")
+	c.Assert(args.sassImporters, qt.HasLen, 1)
+
+	r := args.importResolvers[0]
+	u, err := r.CanonicalizeURL("godartsass:variables")
+	c.Assert(err, qt.IsNil)
+	c.Assert(u, qt.Equals, "godartsass:variables")
+	u, err = r.CanonicalizeURL("foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(u, qt.Equals, "")
+	imp, err := r.Load(u)
+	c.Assert(err, qt.IsNil)
+	c.Assert(imp.Content, qt.Equals, "$font: \"Helvetica; Arial\";\n$primary: #333;\n")
+
+	args = Args{Source: "a{color:$primary}", Variables: map[string]string{"primary": "#333"}, VariablesGlobal: true}
+	c.Assert(args.init(Options{}), qt.IsNil)
+	c.Assert(args.Source, qt.Equals, "@use \"godartsass:variables\" as *;\na{color:$primary}")
+
+	args = Args{Source: "a\n  color: $primary", SourceSyntax: SourceSyntaxSASS, Variables: map[string]string{"primary": "#333"}, VariablesGlobal: true}
+	c.Assert(args.init(Options{}), qt.IsNil)
+	c.Assert(args.Source, qt.Equals, "@use \"godartsass:variables\" as *\na\n  color: $primary")
+
+	for _, vars := range []map[string]string{
+		{"1a": "#333"},
+		{"$a": "#333"},
+		{"a": ""},
+		{"a": "#333; b: c"},
+		{"a": "#333} b{"},
+		{"a": `"unterminated`},
+		{"a": "#333\n"},
+	} {
+		args = Args{Variables: vars}
+		var invalidErr *InvalidOptionError
+		c.Assert(errors.As(args.init(Options{}), &invalidErr), qt.IsTrue, qt.Commentf("%v", vars))
+		c.Assert(invalidErr.Field, qt.Equals, "Variables")
+	}
+
+	args = Args{SourceSyntax: SourceSyntaxCSS, Variables: map[string]string{"a": "b"}}
+	c.Assert(args.init(Options{}), qt.ErrorMatches, "Variables is not supported for SourceSyntax CSS")
+}
+
+func TestArgsInitImportResolvers(t *testing.T) {
+	c := qt.New(t)
+
+	args := Args{ImportResolver: testResolver{name: "a"}, EntryImporter: testResolver{name: "entry"}, IncludePaths: []string{"/foo"}}
+	c.Assert(args.init(Options{}), qt.IsNil)
+	c.Assert(args.sassImporters, qt.HasLen, 2)
+	c.Assert(args.sassImporters[0].GetImporterId(), qt.Equals, uint32(1))
+	c.Assert(args.sassImporters[1].GetPath(), qt.Equals, "/foo")
+	c.Assert(args.sassEntryImporter.GetImporterId(), qt.Equals, uint32(2))
+
+	call := &call{importResolvers: args.importResolvers}
+	c.Assert(call.importResolver(1), qt.Equals, ImportResolver(testResolver{name: "a"}))
+	c.Assert(call.importResolver(2), qt.Equals, ImportResolver(testResolver{name: "entry"}))
+	c.Assert(call.importResolver(0), qt.IsNil)
+	c.Assert(call.importResolver(3), qt.IsNil)
+
+	args = Args{ImportResolver: testResolver{name: "a"}, ImportResolvers: []ImportResolver{testResolver{name: "b"}, testResolver{name: "c"}}, IncludePaths: []string{"/foo"}}
+	c.Assert(args.init(Options{}), qt.IsNil)
+	c.Assert(args.sassImporters, qt.HasLen, 4)
+	call.importResolvers = args.importResolvers
+	for i, name := range []string{"a", "b", "c"} {
+		c.Assert(call.importResolver(args.sassImporters[i].GetImporterId()), qt.Equals, ImportResolver(testResolver{name: name}))
+	}
+	c.Assert(args.sassImporters[3].GetPath(), qt.Equals, "/foo")
+}
+
+func TestOptionsInitAlert(t *testing.T) {
+	c := qt.New(t)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	c.Assert(err, qt.IsNil)
+	defer f.Close()
+
+	for _, w := range []io.Writer{nil, &bytes.Buffer{}, f} {
+		opts := Options{Stderr: w}
+		c.Assert(opts.init(), qt.IsNil)
+		c.Assert(*opts.AlertColor, qt.Equals, isTerminal(opts.Stderr))
+		c.Assert(*opts.AlertASCII, qt.IsFalse)
+	}
+	c.Assert(isTerminal(f), qt.IsFalse)
+
+	yes := true
+	opts := Options{Stderr: &bytes.Buffer{}, AlertColor: &yes, AlertASCII: &yes}
+	c.Assert(opts.init(), qt.IsNil)
+	c.Assert(*opts.AlertColor, qt.IsTrue)
+	c.Assert(*opts.AlertASCII, qt.IsTrue)
+}
+
+func TestCompileRequest(t *testing.T) {
+	c := qt.New(t)
+
+	type request = embeddedsass.InboundMessage_CompileRequest
+
+	source := func(expect string) func(c *qt.C, req *request) {
+		return func(c *qt.C, req *request) {
+			c.Assert(req.GetString_().GetSource(), qt.Equals, expect)
+		}
+	}
+	fatalDeprecations := func(expect ...string) func(c *qt.C, req *request) {
+		return func(c *qt.C, req *request) {
+			c.Assert(req.GetFatalDeprecation(), qt.DeepEquals, expect)
+		}
+	}
+
+	files := map[string]string{
+		"styles/main.sass":    "@use \"colors\"",
+		"styles/_colors.scss": "$x: 1;",
+	}
+	fatal := []string{"slash-div", "import"}
+	no, yes := false, true
+
+	for _, test := range []struct {
+		name  string
+		opts  Options
+		args  Args
+		errRe string
+		check func(c *qt.C, req *request)
+	}{
+		{"Defaults", Options{}, Args{Source: "a{b:c}"}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetQuietDeps(), qt.IsFalse)
+			c.Assert(req.GetVerbose(), qt.IsFalse)
+			c.Assert(req.GetSilent(), qt.IsFalse)
+			c.Assert(req.GetCharset(), qt.IsTrue)
+			c.Assert(req.GetAlertColor(), qt.IsFalse)
+			c.Assert(req.GetAlertAscii(), qt.IsFalse)
+			c.Assert(req.GetFatalDeprecation(), qt.HasLen, 0)
+		}},
+		{"Flags", Options{}, Args{Source: "a{b:c}", SilenceDependencyDeprecations: true, Verbose: true, Quiet: true}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetQuietDeps(), qt.IsTrue)
+			c.Assert(req.GetVerbose(), qt.IsTrue)
+			c.Assert(req.GetSilent(), qt.IsTrue)
+		}},
+		{"Charset", Options{}, Args{Source: "a{b:c}", Charset: &no}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetCharset(), qt.IsFalse)
+		}},
+		{"Alert", Options{AlertColor: &yes, AlertASCII: &yes}, Args{Source: "a{b:c}"}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetAlertColor(), qt.IsTrue)
+			c.Assert(req.GetAlertAscii(), qt.IsTrue)
+		}},
+		{"RequireUse", Options{}, Args{Source: "a{b:c}", RequireUse: true}, "", fatalDeprecations("import")},
+		{"RequireUse with FatalDeprecations", Options{}, Args{Source: "a{b:c}", FatalDeprecations: fatal[:1], RequireUse: true}, "", fatalDeprecations("slash-div", "import")},
+		{"RequireUse with import in FatalDeprecations", Options{}, Args{Source: "a{b:c}", FatalDeprecations: fatal, RequireUse: true}, "", fatalDeprecations(fatal...)},
+		{"FatalDeprecations", Options{}, Args{Source: "a{b:c}", FatalDeprecations: fatal[:1]}, "", fatalDeprecations("slash-div")},
+		{"BOM", Options{}, Args{Source: "\ufeffa{b:c}"}, "", source("\ufeffa{b:c}")},
+		{"StripBOM", Options{StripBOM: true}, Args{Source: "\ufeffa{b:c}"}, "", source("a{b:c}")},
+		{"StripBOM VirtualFiles", Options{StripBOM: true}, Args{VirtualFiles: map[string]string{"main.scss": "\ufeffa{b:c}"}, Entry: "main.scss"}, "", source("a{b:c}")},
+		{"SourceBytes", Options{}, Args{SourceBytes: []byte("a{b:c}")}, "", source("a{b:c}")},
+		{"Source and SourceBytes", Options{}, Args{Source: "a{b:c}", SourceBytes: []byte("d{e:f}")}, "", source("a{b:c}")},
+		{"Path", Options{}, Args{Path: "/a/main.scss"}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetPath(), qt.Equals, "/a/main.scss")
+		}},
+		{"Path and SourceBytes", Options{}, Args{Path: "/a/main.scss", SourceBytes: []byte("a{b:c}")}, "Path and Source cannot both be set", nil},
+		{"Path and Variables", Options{}, Args{Path: "/a/main.scss", Variables: map[string]string{"a": "b"}}, "Variables is not supported with Path", nil},
+		{"VirtualFiles", Options{}, Args{VirtualFiles: files, Entry: "styles/main.sass", URL: "file:///ignored.scss"}, "", func(c *qt.C, req *request) {
+			input := req.GetString_()
+			c.Assert(input.GetUrl(), qt.Equals, "virtual:/styles/main.sass")
+			c.Assert(input.GetSource(), qt.Equals, files["styles/main.sass"])
+			c.Assert(input.GetSyntax(), qt.Equals, embeddedsass.Syntax_INDENTED)
+			c.Assert(input.GetImporter().GetImporterId(), qt.Not(qt.Equals), uint32(0))
+		}},
+		{"VirtualFiles partial Entry", Options{}, Args{VirtualFiles: files, Entry: "styles/colors"}, "", func(c *qt.C, req *request) {
+			c.Assert(req.GetString_().GetUrl(), qt.Equals, "virtual:/styles/_colors.scss")
+		}},
+		{"VirtualFiles without Entry", Options{}, Args{VirtualFiles: files}, "VirtualFiles requires Entry", nil},
+		{"VirtualFiles Entry not found", Options{}, Args{VirtualFiles: files, Entry: "main.sass"}, `Entry "main.sass" not found in VirtualFiles`, nil},
+		{"VirtualFiles and Source", Options{}, Args{VirtualFiles: files, Entry: "styles/main.sass", Source: "a{}"}, "Source and Path cannot be combined with VirtualFiles", nil},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			var reqs compileRequests
+			transpiler, _ := newFakeConnTranspiler(c, test.opts, reqs.handler(echoCompileHandler))
+			defer transpiler.Close()
+
+			_, err := transpiler.Execute(test.args)
+			if test.errRe != "" {
+				c.Assert(err, qt.ErrorMatches, test.errRe)
+				c.Assert(reqs.all(), qt.HasLen, 0)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			test.check(c, reqs.last())
+		})
+	}
+}
+
+func TestIndent(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	defer transpiler.Close()
+
+	source := "@media print {\n  a {\n    b: c;\n  }\n}\n"
+
+	for _, test := range []struct {
+		width    int
+		typ      IndentType
+		style    OutputStyle
+		expected string
+	}{
+		{0, "", "", source},
+		{4, "", "", "@media print {\n    a {\n        b: c;\n    }\n}\n"},
+		{1, IndentTypeTab, "", "@media print {\n\ta {\n\t\tb: c;\n\t}\n}\n"},
+		{0, IndentTypeTab, "", "@media print {\n\ta {\n\t\tb: c;\n\t}\n}\n"},
+		{2, IndentTypeTab, "", "@media print {\n\t\ta {\n\t\t\t\tb: c;\n\t\t}\n}\n"},
+		{4, IndentTypeSpace, OutputStyleCompressed, source},
+	} {
+		result, err := transpiler.Execute(Args{Source: source, OutputStyle: test.style, IndentWidth: test.width, IndentType: test.typ})
+		c.Assert(err, qt.IsNil)
+		c.Assert(result.CSS, qt.Equals, test.expected)
+	}
+
+	// Multi-line comments and strings are left as is.
+	source = "a {\n  /* x\n  y */\n  b: \"c\\\n  d\";\n}\n"
+	result, err := transpiler.Execute(Args{Source: source, IndentType: IndentTypeTab})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "a {\n\t/* x\n  y */\n\tb: \"c\\\n  d\";\n}\n")
+
+	_, err = transpiler.Execute(Args{Source: source, IndentType: "tabs"})
+	c.Assert(err, qt.ErrorMatches, `invalid IndentType "tabs"`)
 }
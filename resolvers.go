@@ -0,0 +1,172 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync"
+)
+
+// RecordingImportResolver is an ImportResolver that records the URLs
+// requested, e.g. to discover the dependencies of a stylesheet.
+// It is safe for concurrent use.
+type RecordingImportResolver struct {
+	inner ImportResolver
+
+	mu        sync.Mutex
+	requested []string
+	loaded    []string
+}
+
+// NewRecordingImportResolver creates a new RecordingImportResolver
+// delegating to inner. If inner is nil, no URLs will be resolved.
+func NewRecordingImportResolver(inner ImportResolver) *RecordingImportResolver {
+	return &RecordingImportResolver{inner: inner}
+}
+
+func (r *RecordingImportResolver) CanonicalizeURL(url string) (string, error) {
+	r.mu.Lock()
+	r.requested = append(r.requested, url)
+	r.mu.Unlock()
+	if r.inner == nil {
+		return "", nil
+	}
+	return r.inner.CanonicalizeURL(url)
+}
+
+func (r *RecordingImportResolver) Load(canonicalizedURL string) (Import, error) {
+	r.mu.Lock()
+	r.loaded = append(r.loaded, canonicalizedURL)
+	r.mu.Unlock()
+	if r.inner == nil {
+		return Import{}, fmt.Errorf("%q not found", canonicalizedURL)
+	}
+	return r.inner.Load(canonicalizedURL)
+}
+
+// RequestedURLs returns the URLs passed to CanonicalizeURL, in order.
+func (r *RecordingImportResolver) RequestedURLs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.requested...)
+}
+
+// LoadedURLs returns the canonical URLs passed to Load, in order.
+func (r *RecordingImportResolver) LoadedURLs() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.loaded...)
+}
+
+// FSImportResolver is an ImportResolver loading URLs with a given scheme,
+// e.g. "asset:components/button", from a fs.FS.
+// Files are looked up the same way as Dart Sass does for files on disk,
+// trying the .scss, .sass and .css extensions, partials starting with "_"
+// and index files.
+type FSImportResolver struct {
+	scheme string
+	fs     fs.FS
+}
+
+// NewFSImportResolver creates a new FSImportResolver for URLs with the
+// given scheme, e.g. "asset", reading from fsys.
+func NewFSImportResolver(scheme string, fsys fs.FS) *FSImportResolver {
+	return &FSImportResolver{scheme: scheme, fs: fsys}
+}
+
+func (r *FSImportResolver) CanonicalizeURL(url string) (string, error) {
+	prefix := r.scheme + ":"
+	if !strings.HasPrefix(url, prefix) {
+		return "", nil
+	}
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(url, prefix)), "/")
+
+	for _, candidate := range fsCandidates(name) {
+		fi, err := fs.Stat(r.fs, candidate)
+		if err == nil && !fi.IsDir() {
+			return prefix + candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (r *FSImportResolver) Load(canonicalizedURL string) (Import, error) {
+	name := strings.TrimPrefix(canonicalizedURL, r.scheme+":")
+	b, err := fs.ReadFile(r.fs, name)
+	if err != nil {
+		return Import{}, err
+	}
+	return Import{Content: string(b), SourceSyntax: sourceSyntaxFromURL(name)}, nil
+}
+
+// virtualFilesScheme is the URL scheme of the files in Args.VirtualFiles.
+const virtualFilesScheme = "virtual"
+
+// virtualFilesImportResolver resolves Args.VirtualFiles, keyed by cleaned
+// path without a leading slash.
+// The canonical URLs are on the form virtual:/path, so Dart Sass can
+// resolve relative loads against them.
+type virtualFilesImportResolver map[string]string
+
+func newVirtualFilesImportResolver(files map[string]string) virtualFilesImportResolver {
+	r := make(virtualFilesImportResolver, len(files))
+	for name, content := range files {
+		r[cleanVirtualPath(name)] = content
+	}
+	return r
+}
+
+// cleanVirtualPath cleans name and removes any leading slash.
+func cleanVirtualPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (r virtualFilesImportResolver) CanonicalizeURL(url string) (string, error) {
+	prefix := virtualFilesScheme + ":"
+	if !strings.HasPrefix(url, prefix) {
+		return "", nil
+	}
+	name := cleanVirtualPath(strings.TrimPrefix(url, prefix))
+
+	for _, candidate := range fsCandidates(name) {
+		if _, found := r[candidate]; found {
+			return prefix + "/" + candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
+func (r virtualFilesImportResolver) Load(canonicalizedURL string) (Import, error) {
+	name := cleanVirtualPath(strings.TrimPrefix(canonicalizedURL, virtualFilesScheme+":"))
+	content, found := r[name]
+	if !found {
+		return Import{}, fmt.Errorf("%q not found", canonicalizedURL)
+	}
+	return Import{Content: content, SourceSyntax: sourceSyntaxFromURL(name)}, nil
+}
+
+// fsCandidates returns the filenames to try for name, in order.
+func fsCandidates(name string) []string {
+	dir, base := path.Split(name)
+	partial := dir + "_" + base
+
+	switch path.Ext(name) {
+	case ".scss", ".sass", ".css":
+		return []string{name, partial}
+	}
+
+	var candidates []string
+	for _, ext := range []string{".scss", ".sass", ".css"} {
+		candidates = append(candidates, name+ext, partial+ext)
+	}
+	for _, ext := range []string{".scss", ".sass", ".css"} {
+		candidates = append(candidates, path.Join(name, "_index"+ext), path.Join(name, "index"+ext))
+	}
+	return candidates
+}
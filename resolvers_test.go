@@ -0,0 +1,428 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+	qt "github.com/frankban/quicktest"
+)
+
+func TestRecordingImportResolver(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newImportCompileHandler("colors"))
+	defer transpiler.Close()
+
+	resolver := NewRecordingImportResolver(fakeImportResolver{content: "a{b:c}"})
+	_, err := transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: resolver})
+	c.Assert(err, qt.IsNil)
+	c.Assert(resolver.RequestedURLs(), qt.DeepEquals, []string{"colors"})
+	c.Assert(resolver.LoadedURLs(), qt.DeepEquals, []string{"file:///colors.scss"})
+
+	noop := NewRecordingImportResolver(nil)
+	_, err = transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: noop})
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(noop.RequestedURLs(), qt.DeepEquals, []string{"colors"})
+	c.Assert(noop.LoadedURLs(), qt.IsNil)
+}
+
+func TestFSImportResolver(t *testing.T) {
+	c := qt.New(t)
+
+	fsys := fstest.MapFS{
+		"components/_button.scss": {Data: []byte("a{b:c}")},
+		"components/card.sass":    {Data: []byte("a\n  b: c")},
+		"theme/_index.scss":       {Data: []byte("$x: 1;")},
+		"plain.css":               {Data: []byte("p{}")},
+	}
+	r := NewFSImportResolver("asset", fsys)
+
+	for _, test := range []struct {
+		url    string
+		expect string
+	}{
+		{"asset:components/button", "asset:components/_button.scss"},
+		{"asset:components/_button", "asset:components/_button.scss"},
+		{"asset:components/button.scss", "asset:components/_button.scss"},
+		{"asset:/components/../components/card", "asset:components/card.sass"},
+		{"asset:theme", "asset:theme/_index.scss"},
+		{"asset:plain", "asset:plain.css"},
+		{"asset:components", ""},
+		{"asset:missing", ""},
+		{"components/button", ""},
+		{"other:components/button", ""},
+	} {
+		u, err := r.CanonicalizeURL(test.url)
+		c.Assert(err, qt.IsNil)
+		c.Assert(u, qt.Equals, test.expect, qt.Commentf(test.url))
+	}
+
+	imp, err := r.Load("asset:components/card.sass")
+	c.Assert(err, qt.IsNil)
+	c.Assert(imp, qt.Equals, Import{Content: "a\n  b: c", SourceSyntax: SourceSyntaxSASS})
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newImportCompileHandler("asset:components/button"))
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: `@use "asset:components/button";`, ImportResolver: r})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "a{b:c}")
+}
+
+func TestVirtualFilesImportResolver(t *testing.T) {
+	c := qt.New(t)
+
+	r := newVirtualFilesImportResolver(map[string]string{
+		"main.scss":                `@use "components/button";`,
+		"/components/_button.scss": "a{b:c}",
+		"theme/_index.sass":        "a\n  b: c",
+	})
+
+	for _, test := range []struct {
+		url    string
+		expect string
+	}{
+		{"virtual:main", "virtual:/main.scss"},
+		{"virtual:/components/button", "virtual:/components/_button.scss"},
+		{"virtual:components/_button.scss", "virtual:/components/_button.scss"},
+		{"virtual:/components/../theme", "virtual:/theme/_index.sass"},
+		{"virtual:missing", ""},
+		{"components/button", ""},
+	} {
+		u, err := r.CanonicalizeURL(test.url)
+		c.Assert(err, qt.IsNil)
+		c.Assert(u, qt.Equals, test.expect, qt.Commentf(test.url))
+	}
+
+	imp, err := r.Load("virtual:/theme/_index.sass")
+	c.Assert(err, qt.IsNil)
+	c.Assert(imp, qt.Equals, Import{Content: "a\n  b: c", SourceSyntax: SourceSyntaxSASS})
+	_, err = r.Load("virtual:/missing.scss")
+	c.Assert(err, qt.ErrorMatches, `"virtual:/missing.scss" not found`)
+}
+
+// emptyImportResolver loads whitespace only.
+type emptyImportResolver struct {
+	fakeImportResolver
+}
+
+func (r emptyImportResolver) Load(url string) (Import, error) {
+	return Import{Content: " \n"}, nil
+}
+
+// flakyImportResolver fails the first calls to each method,
+// as many as given by failures.
+type flakyImportResolver struct {
+	fakeImportResolver
+	failures int
+
+	mu                  sync.Mutex
+	canonicalize, loads int
+}
+
+func (r *flakyImportResolver) CanonicalizeURL(url string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.canonicalize++; r.canonicalize <= r.failures {
+		return "", errors.New("canonicalize failed")
+	}
+	return r.fakeImportResolver.CanonicalizeURL(url)
+}
+
+func (r *flakyImportResolver) Load(url string) (Import, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.loads++; r.loads <= r.failures {
+		return Import{}, errors.New("load failed")
+	}
+	return r.fakeImportResolver.Load(url)
+}
+
+func TestImportContent(t *testing.T) {
+	c := qt.New(t)
+
+	onImportContent := func(url, content string) (string, error) {
+		if strings.Contains(content, "fail") {
+			return "", errors.New("boom")
+		}
+		return strings.ReplaceAll(content, "$ENV", "prod"), nil
+	}
+	flaky := func(failures int) ImportResolver {
+		return &flakyImportResolver{fakeImportResolver: fakeImportResolver{content: "a{b:c}"}, failures: failures}
+	}
+	retries := Options{ImportRetries: 2, ImportRetryBackoff: time.Millisecond}
+
+	for _, test := range []struct {
+		name     string
+		opts     Options
+		resolver ImportResolver
+		expect   string
+		errRe    string
+	}{
+		{"Import", Options{}, fakeImportResolver{content: "a{b:c}"}, "a{b:c} file:///colors.scss", ""},
+		{"ValidateUTF8", Options{ValidateUTF8: true}, fakeImportResolver{content: "a{b:\xff}"}, "", `.*invalid UTF-8 in file:///colors.scss at byte offset 4`},
+		{"BOM", Options{}, fakeImportResolver{content: "\ufeffa{b:c}"}, "\ufeffa{b:c} file:///colors.scss", ""},
+		{"StripBOM", Options{StripBOM: true}, fakeImportResolver{content: "\ufeffa{b:c}"}, "a{b:c} file:///colors.scss", ""},
+		{"OnImportContent", Options{OnImportContent: onImportContent}, fakeImportResolver{content: "a{b:$ENV}"}, "a{b:prod} file:///colors.scss", ""},
+		{"OnImportContent error", Options{OnImportContent: onImportContent}, fakeImportResolver{content: "fail"}, "", ".*boom"},
+		{"Empty", Options{}, emptyImportResolver{}, " \n", ""},
+		{"ErrorOnEmptyImport", Options{ErrorOnEmptyImport: true}, emptyImportResolver{}, "", `.*import "file:///colors.scss" has no content`},
+		{"No retries", Options{}, flaky(1), "", ".*canonicalize failed"},
+		{"ImportRetries", retries, flaky(1), "a{b:c} file:///colors.scss", ""},
+		{"ImportRetries exhausted", retries, flaky(3), "", ".*canonicalize failed"},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			transpiler, _ := newFakeConnTranspiler(c, test.opts, newImportCompileHandler("colors"))
+			defer transpiler.Close()
+
+			result, err := transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: test.resolver})
+			if test.errRe != "" {
+				c.Assert(err, qt.ErrorMatches, test.errRe)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(result.CSS, qt.Equals, test.expect)
+		})
+	}
+}
+
+func TestEntryImporterID(t *testing.T) {
+	c := qt.New(t)
+
+	var reqs compileRequests
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, reqs.handler(echoCompileHandler))
+	defer transpiler.Close()
+
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(reqs.last().GetString_().GetImporter().GetImporterId(), qt.Equals, uint32(0))
+	_, err = transpiler.Execute(Args{Source: "a{b:c}", ImportResolver: fakeImportResolver{}, EntryImporter: fakeImportResolver{}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(reqs.last().GetString_().GetImporter().GetImporterId(), qt.Equals, uint32(2))
+}
+
+func TestMaxImports(t *testing.T) {
+	c := qt.New(t)
+
+	// Keeps importing a new URL until an import fails.
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		var n uint32
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			send(compilationID, importRequest(m.CanonicalizeResponse.Id, 1, m.CanonicalizeResponse.GetUrl()))
+			return
+		case *embeddedsass.InboundMessage_ImportResponse_:
+			n = m.ImportResponse.Id
+			if failure := m.ImportResponse.GetError(); failure != "" || n == 1000 {
+				send(compilationID, compileFailure(fmt.Sprintf("%s after %d imports", failure, n), nil))
+				return
+			}
+		default:
+			return
+		}
+		send(compilationID, canonicalizeRequest(n+1, 1, fmt.Sprintf("a%d", n+1)))
+	}
+
+	for _, test := range []struct {
+		max    int
+		expect string
+	}{
+		{0, "after 1000 imports"},
+		{10, "import limit of 10 exceeded after 11 imports"},
+	} {
+		transpiler, _ := newFakeConnTranspiler(c, Options{MaxImports: test.max}, handler)
+		_, err := transpiler.Execute(Args{Source: `@use "a1";`, ImportResolver: fakeImportResolver{}})
+		c.Assert(err, qt.ErrorMatches, ".*"+test.expect)
+		c.Assert(transpiler.Close(), qt.IsNil)
+	}
+}
+
+func TestOnResolverImportResolved(t *testing.T) {
+	c := qt.New(t)
+
+	var resolved [][2]string
+	opts := Options{
+		OnResolverImportResolved: func(requestedURL, canonicalURL string) {
+			resolved = append(resolved, [2]string{requestedURL, canonicalURL})
+		},
+	}
+	transpiler, _ := newFakeConnTranspiler(c, opts, newImportCompileHandler("colors"))
+	defer transpiler.Close()
+
+	_, err := transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: fakeImportResolver{}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(resolved, qt.DeepEquals, [][2]string{{"colors", "file:///colors.scss"}})
+
+	// Not resolved.
+	_, err = transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: testResolver{}})
+	c.Assert(err, qt.IsNotNil)
+	c.Assert(resolved, qt.HasLen, 1)
+}
+
+func TestOnModuleLoad(t *testing.T) {
+	c := qt.New(t)
+
+	// Loads each URL in turn using the first importer.
+	urls := []string{"colors", "fonts"}
+	var (
+		compilation uint32
+		importerID  uint32
+		next        int
+	)
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			compilation, importerID, next = compilationID, m.CompileRequest.Importers[0].GetImporterId(), 0
+			send(compilation, canonicalizeRequest(uint32(next), importerID, urls[next]))
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			send(compilation, importRequest(uint32(next+10), importerID, m.CanonicalizeResponse.GetUrl()))
+		case *embeddedsass.InboundMessage_ImportResponse_:
+			if next++; next < len(urls) {
+				send(compilation, canonicalizeRequest(uint32(next), importerID, urls[next]))
+				return
+			}
+			send(compilation, compileSuccess("", ""))
+		}
+	}
+
+	var loaded []string
+	opts := Options{
+		OnModuleLoad: func(url, canonicalURL string) {
+			loaded = append(loaded, url+" => "+canonicalURL)
+		},
+	}
+	transpiler, _ := newFakeConnTranspiler(c, opts, handler)
+	defer transpiler.Close()
+
+	_, err := transpiler.Execute(Args{Source: `@use "colors"; @use "fonts";`, ImportResolver: fakeImportResolver{}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(loaded, qt.DeepEquals, []string{"colors => file:///colors.scss", "fonts => file:///fonts.scss"})
+}
+
+func TestMissingImporters(t *testing.T) {
+	c := qt.New(t)
+
+	// Sends a file import request and then a canonicalize request for
+	// an unknown compilation, failing the compilation with the errors.
+	var (
+		errs        []string
+		compilation uint32
+	)
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			compilation = compilationID
+			errs = nil
+			send(compilationID, &embeddedsass.OutboundMessage{
+				Message: &embeddedsass.OutboundMessage_FileImportRequest_{
+					FileImportRequest: &embeddedsass.OutboundMessage_FileImportRequest{Id: 1, ImporterId: 42, Url: "foo"},
+				},
+			})
+		case *embeddedsass.InboundMessage_FileImportResponse_:
+			errs = append(errs, m.FileImportResponse.GetError())
+			send(compilationID+100, canonicalizeRequest(2, 1, "foo"))
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			errs = append(errs, m.CanonicalizeResponse.GetError())
+			send(compilation, compileFailure(strings.Join(errs, ", "), nil))
+		}
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	for i := 0; i < 2; i++ {
+		_, err := transpiler.Execute(Args{Source: `@use "foo";`, ImportResolver: fakeImportResolver{}})
+		c.Assert(err, qt.ErrorMatches, `.*file importer with ID 42 not found, compilation with ID 10\d not found`)
+	}
+}
+
+// prefixImportResolver resolves the URLs starting with its prefix.
+type prefixImportResolver string
+
+func (r prefixImportResolver) CanonicalizeURL(url string) (string, error) {
+	if strings.HasPrefix(url, string(r)) {
+		return url, nil
+	}
+	return "", nil
+}
+
+func (r prefixImportResolver) Load(url string) (Import, error) {
+	return Import{Content: url}, nil
+}
+
+func TestImportOrigins(t *testing.T) {
+	c := qt.New(t)
+
+	// Canonicalizes each URL by trying the importers in order, as Dart Sass.
+	urls := []string{"b:y", "a:x"}
+	var (
+		compilation uint32
+		importers   []*embeddedsass.InboundMessage_CompileRequest_Importer
+		u, i        int
+	)
+	canonicalize := func(send fakeSender) {
+		send(compilation, canonicalizeRequest(uint32(u*10+i), importers[i].GetImporterId(), urls[u]))
+	}
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		switch m := msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			compilation, importers, u, i = compilationID, m.CompileRequest.Importers, 0, 0
+			canonicalize(send)
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			i++
+			if m.CanonicalizeResponse.GetUrl() != "" || i == len(importers) {
+				u, i = u+1, 0
+			}
+			if u < len(urls) {
+				canonicalize(send)
+				return
+			}
+			send(compilation, compileSuccess("", ""))
+		}
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	args := Args{ImportResolvers: []ImportResolver{prefixImportResolver("a:"), prefixImportResolver("b:")}}
+	result, err := transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.ImportOrigins, qt.DeepEquals, map[string]int{"a:x": 1, "b:y": 2})
+
+	// The variables module is not counted.
+	args.Variables = map[string]string{"a": "1"}
+	result, err = transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.ImportOrigins, qt.DeepEquals, map[string]int{"a:x": 1, "b:y": 2})
+}
+
+type slowImportResolver struct {
+	fakeImportResolver
+	delay time.Duration
+}
+
+func (r slowImportResolver) Load(url string) (Import, error) {
+	time.Sleep(r.delay)
+	return r.fakeImportResolver.Load(url)
+}
+
+func TestImportResolverDuration(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newImportCompileHandler("colors"))
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: `@use "colors";`, ImportResolver: slowImportResolver{delay: 50 * time.Millisecond}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.ImportResolverDuration >= 50*time.Millisecond, qt.IsTrue)
+}
@@ -0,0 +1,216 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// sourceMap holds the parts of a version 3 source map we need.
+type sourceMap struct {
+	Sources        []string  `json:"sources"`
+	SourcesContent []*string `json:"sourcesContent"`
+	Mappings       string    `json:"mappings"`
+}
+
+func parseSourceMap(s string) (sourceMap, error) {
+	var m sourceMap
+	err := json.Unmarshal([]byte(s), &m)
+	return m, err
+}
+
+// hasAllSources reports whether every entry in sources has content.
+func (m sourceMap) hasAllSources() bool {
+	if len(m.SourcesContent) != len(m.Sources) {
+		return false
+	}
+	for _, content := range m.SourcesContent {
+		if content == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// relativeSources rewrites the file: URLs in the sources of sourceMap to
+// slash-separated paths relative to dir. Only the sources array is
+// replaced, the rest of sourceMap is kept byte for byte.
+func relativeSources(sourceMap, dir string) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(sourceMap))
+	if tok, err := dec.Token(); err != nil {
+		return "", err
+	} else if tok != json.Delim('{') {
+		return "", errors.New("source map is not a JSON object")
+	}
+
+	var (
+		sources    []string
+		start, end int
+	)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return "", err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return "", err
+		}
+		if key == "sources" {
+			if err := json.Unmarshal(value, &sources); err != nil {
+				return "", err
+			}
+			end = int(dec.InputOffset())
+			start = end - len(value)
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return "", err
+	}
+	if sources == nil {
+		return sourceMap, nil
+	}
+
+	for i, source := range sources {
+		u, err := url.Parse(source)
+		if err != nil || u.Scheme != "file" {
+			continue
+		}
+		rel, err := filepath.Rel(dir, filepath.FromSlash(u.Path))
+		if err != nil {
+			continue
+		}
+		sources[i] = filepath.ToSlash(rel)
+	}
+
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(sources); err != nil {
+		return "", err
+	}
+	return sourceMap[:start] + strings.TrimSuffix(b.String(), "\n") + sourceMap[end:], nil
+}
+
+// mapping is a decoded source map segment with a source.
+type mapping struct {
+	genLine   int
+	genColumn int
+	source    int
+}
+
+// decodeMappings decodes the Base64 VLQ encoded mappings, skipping
+// segments without a source.
+func (m sourceMap) decodeMappings() ([]mapping, error) {
+	var (
+		mappings []mapping
+		source   int
+		values   []int
+	)
+
+	for genLine, line := range strings.Split(m.Mappings, ";") {
+		var genColumn int
+		for _, segment := range strings.Split(line, ",") {
+			if segment == "" {
+				continue
+			}
+			values = values[:0]
+			var value, shift int
+			for i := 0; i < len(segment); i++ {
+				digit := strings.IndexByte(base64Chars, segment[i])
+				if digit == -1 {
+					return nil, fmt.Errorf("invalid mapping segment %q", segment)
+				}
+				value += (digit & 31) << shift
+				if digit&32 != 0 {
+					shift += 5
+					continue
+				}
+				if value&1 != 0 {
+					value = -(value >> 1)
+				} else {
+					value >>= 1
+				}
+				values = append(values, value)
+				value, shift = 0, 0
+			}
+			if shift != 0 || len(values) == 0 {
+				return nil, fmt.Errorf("invalid mapping segment %q", segment)
+			}
+			genColumn += values[0]
+			if len(values) < 4 {
+				continue
+			}
+			source += values[1]
+			if source < 0 || source >= len(m.Sources) {
+				return nil, fmt.Errorf("invalid source index %d in mappings", source)
+			}
+			mappings = append(mappings, mapping{genLine: genLine, genColumn: genColumn, source: source})
+		}
+	}
+
+	return mappings, nil
+}
+
+const base64Chars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+// SplitBySource partitions the CSS into the top-level statements
+// originating from each source, keyed by the source URLs in the source map.
+// A statement belongs to the source of its first mapped position, statements
+// without any are keyed by an empty string.
+// This requires a source map, see Args.EnableSourceMap.
+func (r Result) SplitBySource() (map[string]string, error) {
+	if r.SourceMap == "" {
+		return nil, errors.New("SplitBySource requires a source map")
+	}
+	m, err := parseSourceMap(r.SourceMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse source map: %w", err)
+	}
+	mappings, err := m.decodeMappings()
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets of the start of each line in the CSS.
+	lineStarts := []int{0}
+	for i := 0; i < len(r.CSS); i++ {
+		if r.CSS[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+
+	chunks := make(map[string][]string)
+	next := 0
+	for _, stmt := range topLevelStatements(r.CSS) {
+		source := ""
+		for ; next < len(mappings); next++ {
+			mp := mappings[next]
+			if mp.genLine >= len(lineStarts) {
+				next = len(mappings)
+				break
+			}
+			offset := lineStarts[mp.genLine] + mp.genColumn
+			if offset >= stmt[1] {
+				break
+			}
+			if offset >= stmt[0] && source == "" {
+				source = m.Sources[mp.source]
+			}
+		}
+		chunks[source] = append(chunks[source], r.CSS[stmt[0]:stmt[1]])
+	}
+
+	split := make(map[string]string, len(chunks))
+	for source, stmts := range chunks {
+		split[source] = strings.Join(stmts, "\n")
+	}
+
+	return split, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestParseSourceMapHasAllSources(t *testing.T) {
+	c := qt.New(t)
+
+	for _, test := range []struct {
+		sourceMap string
+		expect    bool
+	}{
+		{`{"version":3,"sources":["a.scss","b.scss"],"sourcesContent":["a{}","b{}"],"mappings":""}`, true},
+		{`{"version":3,"sources":[],"mappings":""}`, true},
+		{`{"version":3,"sources":["a.scss","b.scss"],"sourcesContent":["a{}",null],"mappings":""}`, false},
+		{`{"version":3,"sources":["a.scss","b.scss"],"sourcesContent":["a{}"],"mappings":""}`, false},
+		{`{"version":3,"sources":["a.scss"],"mappings":""}`, false},
+	} {
+		m, err := parseSourceMap(test.sourceMap)
+		c.Assert(err, qt.IsNil)
+		c.Assert(m.hasAllSources(), qt.Equals, test.expect, qt.Commentf(test.sourceMap))
+	}
+}
+
+func TestRelativeSources(t *testing.T) {
+	c := qt.New(t)
+
+	sourceMap := `{"version":3,"sources":["file:///project/src/a.scss","file:///project/src/sub/_b.scss","file:///project/lib/c.scss","data:;charset=utf-8,a%7B%7D","https://example.com/d.scss?a&b"],"names":[],"sourcesContent":["a{b:\"&<>\"}"],"mappings":"AAAA"}`
+	s, err := relativeSources(sourceMap, "/project/src")
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, `{"version":3,"sources":["a.scss","sub/_b.scss","../lib/c.scss","data:;charset=utf-8,a%7B%7D","https://example.com/d.scss?a&b"],"names":[],"sourcesContent":["a{b:\"&<>\"}"],"mappings":"AAAA"}`)
+
+	s, err = relativeSources("{\n  \"version\": 3,\n  \"sources\": [ \"file:///project/src/a.scss\" ],\n  \"mappings\": \"\"\n}", "/project/src")
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, "{\n  \"version\": 3,\n  \"sources\": [\"a.scss\"],\n  \"mappings\": \"\"\n}")
+
+	s, err = relativeSources(`{ "version": 3, "mappings": "" }`, "/project/src")
+	c.Assert(err, qt.IsNil)
+	c.Assert(s, qt.Equals, `{ "version": 3, "mappings": "" }`)
+
+	_, err = relativeSources("{", "/project/src")
+	c.Assert(err, qt.IsNotNil)
+}
+
+func TestSplitBySource(t *testing.T) {
+	c := qt.New(t)
+
+	result := Result{
+		CSS: "@charset \"UTF-8\";\na {\n  b: \"}\";\n}\n\n/* c */\nd {\n  e: f;\n}\ng {\n  h: i;\n}",
+		// a maps to one.scss, d to two.scss, g back to one.scss.
+		SourceMap: `{"version":3,"sources":["one.scss","two.scss"],"mappings":";AAAA;EACE;;;;ACDF;EACE;;ADAF"}`,
+	}
+
+	split, err := result.SplitBySource()
+	c.Assert(err, qt.IsNil)
+	c.Assert(split, qt.DeepEquals, map[string]string{
+		"":         "@charset \"UTF-8\";",
+		"one.scss": "a {\n  b: \"}\";\n}\ng {\n  h: i;\n}",
+		"two.scss": "d {\n  e: f;\n}",
+	})
+
+	_, err = Result{CSS: "a{}"}.SplitBySource()
+	c.Assert(err, qt.ErrorMatches, "SplitBySource requires a source map")
+
+	_, err = Result{CSS: "a{}", SourceMap: `{"sources":["a"],"mappings":"A!AA"}`}.SplitBySource()
+	c.Assert(err, qt.ErrorMatches, `invalid mapping segment "A!AA"`)
+
+	_, err = Result{CSS: "a{}", SourceMap: `{"sources":["a"],"mappings":"ACAA"}`}.SplitBySource()
+	c.Assert(err, qt.ErrorMatches, `invalid source index 1 in mappings`)
+}
+
+func TestSourceMapRelativeTo(t *testing.T) {
+	c := qt.New(t)
+
+	handler := newResponseHandler(compileSuccess("a{b:c}", `{"version":3,"sources":["file:///project/src/a.scss"],"mappings":"AAAA"}`))
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: "a{b:c}", EnableSourceMap: true, SourceMapRelativeTo: "/project/src"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.SourceMap, qt.Equals, `{"version":3,"sources":["a.scss"],"mappings":"AAAA"}`)
+}
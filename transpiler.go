@@ -8,22 +8,31 @@
 package godartsass
 
 import (
-	"encoding/binary"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cli/safeexec"
 
 	"github.com/bep/godartsass/v2/internal/embeddedsass"
+	"github.com/bep/godartsass/v2/internal/framing"
 	"github.com/bep/godartsass/v2/internal/godartsasstesting"
 	"google.golang.org/protobuf/proto"
 )
@@ -34,6 +43,10 @@ const defaultDartSassBinaryFilename = "sass"
 // is about to be shut down.
 var ErrShutdown = errors.New("connection is shut down")
 
+// ErrRestarted will be returned from Execute if the Dart Sass process was
+// restarted while the call was in flight.
+var ErrRestarted = errors.New("dart sass process was restarted")
+
 // Start creates and starts a new SCSS transpiler that communicates with the
 // Dass Sass Embedded protocol via Stdin and Stdout.
 //
@@ -51,6 +64,47 @@ func Start(opts Options) (*Transpiler, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	startConn := func() (byteReadWriteCloser, error) {
+		for attempt := 0; ; attempt++ {
+			conn, err := startProcess(bin, opts)
+			if err == nil {
+				return conn, nil
+			}
+			if attempt >= opts.StartRetries {
+				return nil, err
+			}
+			time.Sleep(time.Duration(attempt+1) * startRetryBackoff)
+		}
+	}
+
+	return newTranspiler(opts, startConn)
+}
+
+// Compile starts a new Transpiler, transpiles args with Execute and closes it,
+// e.g. for scripts and tests.
+// Starting Dart Sass is slow, so use Start for more than one compilation.
+// Any error from closing the Transpiler is ignored, as the result is
+// complete at that point.
+func Compile(opts Options, args Args) (Result, error) {
+	t, err := Start(opts)
+	if err != nil {
+		return Result{}, err
+	}
+	defer t.Close()
+
+	return t.Execute(args)
+}
+
+// startRetryBackoff is multiplied with the attempt number to get the
+// wait time before retrying to start the Dart Sass process.
+const startRetryBackoff = 100 * time.Millisecond
+
+// exitStderrTimeout is how long to wait for a Dart Sass process that closed
+// its stdout to exit, to get all of its stderr for the error.
+const exitStderrTimeout = time.Second
+
+func startProcess(bin string, opts Options) (byteReadWriteCloser, error) {
 	cmd := exec.Command(bin)
 	cmd.Args = append(cmd.Args, "--embedded")
 	cmd.Stderr = opts.Stderr
@@ -60,23 +114,126 @@ func Start(opts Options) (*Transpiler, error) {
 		return nil, err
 	}
 
+	// Only set in tests.
+	if opts.testingStartErr != nil {
+		if err := opts.testingStartErr(); err != nil {
+			conn.closePipes()
+			return nil, err
+		}
+	}
+
 	if err := conn.Start(); err != nil {
 		return nil, err
 	}
 
+	return conn, nil
+}
+
+// newTranspiler creates a new Transpiler communicating via the conn
+// returned from startConn.
+func newTranspiler(opts Options, startConn func() (byteReadWriteCloser, error)) (*Transpiler, error) {
+	conn, err := startConn()
+	if err != nil {
+		return nil, err
+	}
+
 	t := &Transpiler{
-		opts:    opts,
-		conn:    conn,
-		lenBuf:  make([]byte, binary.MaxVarintLen64),
-		idBuf:   make([]byte, binary.MaxVarintLen64),
-		pending: make(map[uint32]*call),
+		opts:      opts,
+		startConn: startConn,
+		conn:      conn,
+		writer:    framing.NewWriter(conn),
+		pending:   make(map[uint32]*call),
+		cancelled: make(map[uint32]bool),
 	}
 
-	go t.input()
+	go t.input(conn)
+
+	if !opts.VerifyStart {
+		return t, nil
+	}
+
+	if err := t.handshake(); err != nil {
+		// Make sure the process is gone and that we have all of its stderr.
+		conn.Close()
+		if s, ok := conn.(stderrTailer); ok {
+			// The error may already have it if the process exited.
+			if stderr := strings.TrimSpace(s.stderr()); stderr != "" && !strings.Contains(err.Error(), stderr) {
+				err = fmt.Errorf("%w: %s", err, stderr)
+			}
+		}
+		return nil, fmt.Errorf("failed to start Dart Sass: %w", err)
+	}
 
 	return t, nil
 }
 
+// handshake sends a version request to Dart Sass and waits for the response
+// to verify that the process is up and running.
+func (t *Transpiler) handshake() error {
+	// The compilation ID 0 is reserved for version requests.
+	const compilationID = 0
+
+	call := &call{
+		id: compilationID,
+		Request: &embeddedsass.InboundMessage{
+			Message: &embeddedsass.InboundMessage_VersionRequest_{
+				VersionRequest: &embeddedsass.InboundMessage_VersionRequest{Id: 1},
+			},
+		},
+		Done: make(chan *call, 1),
+	}
+
+	t.mu.Lock()
+	t.pending[compilationID] = call
+	conn := t.conn
+	t.mu.Unlock()
+
+	if err := t.sendInboundMessage(conn, compilationID, call.Request, 0); err != nil {
+		t.mu.Lock()
+		delete(t.pending, compilationID)
+		t.mu.Unlock()
+		return err
+	}
+
+	select {
+	case call = <-call.Done:
+	case <-time.After(t.opts.Timeout):
+		t.mu.Lock()
+		delete(t.pending, compilationID)
+		t.mu.Unlock()
+		return errors.New("timeout waiting for Dart Sass to respond to version request")
+	}
+
+	if call.Error != nil {
+		return call.Error
+	}
+
+	if v := call.Response.GetVersionResponse(); v != nil {
+		t.version = DartSassVersion{
+			ProtocolVersion:       v.GetProtocolVersion(),
+			CompilerVersion:       v.GetCompilerVersion(),
+			ImplementationVersion: v.GetImplementationVersion(),
+			ImplementationName:    v.GetImplementationName(),
+			ID:                    int(v.GetId()),
+		}
+	}
+
+	return nil
+}
+
+// dartSassVersion returns the version reported by the running Dart Sass
+// process, doing the version request on first use unless done in Start.
+func (t *Transpiler) dartSassVersion() (DartSassVersion, error) {
+	t.versionMu.Lock()
+	defer t.versionMu.Unlock()
+	if t.version == (DartSassVersion{}) {
+		if err := t.handshake(); err != nil {
+			return DartSassVersion{}, err
+		}
+	}
+	return t.version, nil
+}
+
 // Version returns version information about the Dart Sass frameworks used
 // in dartSassEmbeddedFilename.
 func Version(dartSassEmbeddedFilename string) (DartSassVersion, error) {
@@ -113,21 +270,72 @@ type DartSassVersion struct {
 type Transpiler struct {
 	opts Options
 
+	// Starts a new Dart Sass process.
+	startConn func() (byteReadWriteCloser, error)
+
 	// stdin/stdout of the Dart Sass protocol
 	conn   byteReadWriteCloser
-	lenBuf []byte
-	idBuf  []byte
-	msgBuf []byte
+	writer *framing.Writer
+
+	// From the version response in the handshake.
+	versionMu sync.Mutex
+	version   DartSassVersion
 
 	closing  bool
 	shutdown bool
+	draining bool
+
+	// Set by TestingResetBuffers, consumed by the input loop.
+	resetBuffers atomic.Bool
 
 	// Protects the sending of messages to Dart Sass.
 	sendMu sync.Mutex
 
+	// Counters reported by Stats.
+	warningsTotal     atomic.Uint64
+	deprecationsTotal atomic.Uint64
+	errorsTotal       atomic.Uint64
+
+	// Serializes compilations with Options.IsolatePerCompile.
+	isolateMu sync.Mutex
+	compiled  bool // Protected by isolateMu.
+
 	mu      sync.Mutex // Protects all below.
 	seq     uint32
 	pending map[uint32]*call
+
+	// Set by SetIncludePaths.
+	includePaths []string
+
+	// Calls removed from pending by ExecuteContext, waiting for a response.
+	cancelled map[uint32]bool
+
+	// The last Options.LogHistorySize log events, recentLogs[recentLogsStart]
+	// is the oldest when full.
+	recentLogs      []LogEvent
+	recentLogsStart int
+}
+
+// TestingResetBuffers releases the read buffer which grows to fit the
+// largest message received so far. The buffer is released before the next
+// message is read.
+// Should only be used in tests and benchmarks.
+func (t *Transpiler) TestingResetBuffers() {
+	if !godartsasstesting.IsTest {
+		panic("TestingResetBuffers should only be used in tests")
+	}
+	t.resetBuffers.Store(true)
+}
+
+// TestingSeq returns the last compilation ID used.
+// Should only be used in tests.
+func (t *Transpiler) TestingSeq() uint32 {
+	if !godartsasstesting.IsTest {
+		panic("TestingSeq should only be used in tests")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.seq
 }
 
 // IsShutDown checks if all pending calls have been shut down.
@@ -142,33 +350,185 @@ func (t *Transpiler) IsShutDown() bool {
 }
 
 // Result holds the result returned from Execute.
+//
+// Note that CSS and SourceMap is all the information the protocol's
+// CompileSuccess message carries.
+//
+// Result is not comparable with ==, as it holds slices and maps.
+// Compare its fields instead.
 type Result struct {
-	CSS       string
+	// The compiled CSS.
+	CSS string
+
+	// The JSON encoded source map, empty if Args.EnableSourceMap was not set.
 	SourceMap string
+
+	// Set if Args.SourceMapIncludeSources was set and the source map
+	// has content for all of its sources.
+	SourceMapHasAllSources bool
+
+	// The output style used, after defaults have been applied.
+	EffectiveOutputStyle OutputStyle
+
+	// The total time spent in custom import resolvers.
+	ImportResolverDuration time.Duration
+
+	// The canonical URLs of all stylesheets loaded by Dart Sass, including
+	// the entry point if it has a URL, e.g. for cache invalidation.
+	LoadedURLs []string
+
+	// The canonical URLs resolved by custom import resolvers mapped to the
+	// resolver's position in the chain starting at 1, i.e. ImportResolver
+	// if set, then ImportResolvers and EntryImporter.
+	ImportOrigins map[string]int
+
+	// Warnings and other log events from the compilation.
+	diagnostics []Diagnostic
+
+	// Shared by copies of the Result, nil if not created by Execute.
+	sha256 *sha256Cache
+}
+
+// sha256Cache holds the hash of css. It's keyed by the CSS so that a copy
+// of the Result with different CSS doesn't get a stale hash.
+type sha256Cache struct {
+	mu  sync.Mutex
+	css string
+	sum string
+}
+
+// SHA256 returns the hex encoded SHA-256 hash of the CSS.
+// For Results returned by Execute the hash is computed on first use and cached.
+func (r Result) SHA256() string {
+	if r.sha256 == nil {
+		return sha256Hex(r.CSS)
+	}
+	r.sha256.mu.Lock()
+	defer r.sha256.mu.Unlock()
+	if r.sha256.sum == "" || r.sha256.css != r.CSS {
+		r.sha256.css, r.sha256.sum = r.CSS, sha256Hex(r.CSS)
+	}
+	return r.sha256.sum
+}
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// GzipCSS returns the CSS compressed with gzip using the given
+// compression level, see compress/gzip.
+func (r Result) GzipCSS(level int) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, r.CSS); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
 }
 
 // SassError is the error returned from Execute on compile errors.
 type SassError struct {
-	Message string `json:"message"`
-	Span    struct {
-		Text  string `json:"text"`
-		Start struct {
-			Offset int `json:"offset"`
-			Column int `json:"column"`
-		} `json:"start"`
-		End struct {
-			Offset int `json:"offset"`
-			Column int `json:"column"`
-		} `json:"end"`
-		Url     string `json:"url"`
-		Context string `json:"context"`
-	} `json:"span"`
+	Message string     `json:"message"`
+	Span    SourceSpan `json:"span"`
+
+	// The URL of the span exactly as reported by Dart Sass, while Error
+	// shows it as a cleaned file path.
+	RawURL string `json:"-"`
 }
 
+// SourceSpan is a span of source text.
+type SourceSpan struct {
+	Text  string         `json:"text"`
+	Start SourceLocation `json:"start"`
+	End   SourceLocation `json:"end"`
+
+	// The URL of the source file, empty if unknown.
+	Url string `json:"url"`
+
+	// The source text around the span, if available.
+	Context string `json:"context"`
+}
+
+// SourceLocation is a location in a source file.
+// Offset, Line and Column are 0-based.
+type SourceLocation struct {
+	Offset int `json:"offset"`
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func newSourceLocation(l *embeddedsass.SourceSpan_SourceLocation) SourceLocation {
+	return SourceLocation{Offset: int(l.GetOffset()), Line: int(l.GetLine()), Column: int(l.GetColumn())}
+}
+
+// adjustForPrelude adjusts the span of e to be relative to the source
+// without prelude if the error is in the entry point with the given URL.
+func (e *SassError) adjustForPrelude(url, prelude string) {
+	lines := strings.Count(prelude, "\n")
+	if prelude == "" || e.Span.Url != url || e.Span.Start.Offset < len(prelude) {
+		return
+	}
+	e.Span.Start.Offset -= len(prelude)
+	e.Span.Start.Line -= lines
+	e.Span.End.Offset -= len(prelude)
+	e.Span.End.Line -= lines
+}
+
+// Error returns the error as file:line:column: message, with 1-based
+// line and column, or just the message if the error has no span.
 func (e SassError) Error() string {
 	span := e.Span
+	if span == (SourceSpan{}) {
+		return e.Message
+	}
+	if span.Url == "" {
+		return fmt.Sprintf("%d:%d: %s", span.Start.Line+1, span.Start.Column+1, e.Message)
+	}
 	file := path.Clean(strings.TrimPrefix(span.Url, "file:"))
-	return fmt.Sprintf("file: %q, context: %q: %s", file, span.Context, e.Message)
+	return fmt.Sprintf("%s:%d:%d: %s", file, span.Start.Line+1, span.Start.Column+1, e.Message)
+}
+
+// shutdownPollInterval is how often Shutdown checks for pending calls.
+const shutdownPollInterval = 10 * time.Millisecond
+
+// Shutdown gracefully shuts down the Transpiler. New calls will fail with
+// ErrShutdown, while Shutdown waits for the pending calls to complete
+// before closing the stream to Dart Sass.
+// If ctx expires first, the stream is closed, failing the remaining
+// calls, and the context's error is returned.
+func (t *Transpiler) Shutdown(ctx context.Context) error {
+	t.mu.Lock()
+	if t.closing || t.draining {
+		t.mu.Unlock()
+		return ErrShutdown
+	}
+	t.draining = true
+	t.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		t.mu.Lock()
+		n := len(t.pending)
+		t.mu.Unlock()
+		if n == 0 {
+			return t.Close()
+		}
+		select {
+		case <-ctx.Done():
+			t.Close()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 }
 
 // Close closes the stream to the embedded Dart Sass Protocol, shutting it down.
@@ -200,130 +560,518 @@ func (t *Transpiler) Close() error {
 // If Dart Sass resturns a "compile failure", the error returned will be
 // of type SassError.
 func (t *Transpiler) Execute(args Args) (Result, error) {
-	var result Result
+	return t.ExecuteContext(context.Background(), args)
+}
+
+// ExecuteContext is like Execute, but returns ctx.Err() if ctx is done
+// before Dart Sass responds, so the effective deadline is the earlier of
+// the ctx deadline and Options.Timeout.
+// Dart Sass has no way to cancel a compilation, so it will run to completion,
+// but its result is discarded.
+func (t *Transpiler) ExecuteContext(ctx context.Context, args Args) (result Result, err error) {
+	defer func() {
+		if err != nil {
+			t.errorsTotal.Add(1)
+		}
+	}()
+
+	call, err := t.execute(ctx, &args)
+	if err != nil {
+		return result, err
+	}
+
+	csp := call.Response.Message.(*embeddedsass.OutboundMessage_CompileResponse_)
+
+	switch resp := csp.CompileResponse.Result.(type) {
+	case *embeddedsass.OutboundMessage_CompileResponse_Success:
+		if args.MaxOutputBytes > 0 && len(resp.Success.Css) > args.MaxOutputBytes {
+			return result, fmt.Errorf("CSS output of %d bytes exceeds MaxOutputBytes of %d", len(resp.Success.Css), args.MaxOutputBytes)
+		}
+		result.CSS = resp.Success.Css
+		result.SourceMap = resp.Success.SourceMap
+		result.sha256 = &sha256Cache{}
+		result.EffectiveOutputStyle = args.OutputStyle
+		result.diagnostics = call.diagnostics
+		result.ImportResolverDuration = call.resolverDuration
+		result.ImportOrigins = call.importOrigins
+		for _, u := range csp.CompileResponse.GetLoadedUrls() {
+			if u != variablesURL {
+				result.LoadedURLs = append(result.LoadedURLs, u)
+			}
+		}
+		if args.SourceMapRelativeTo != "" && result.SourceMap != "" {
+			result.SourceMap, err = relativeSources(result.SourceMap, args.SourceMapRelativeTo)
+			if err != nil {
+				return result, fmt.Errorf("failed to parse source map: %w", err)
+			}
+		}
+		if args.SourceMapIncludeSources && result.SourceMap != "" {
+			m, err := parseSourceMap(result.SourceMap)
+			if err != nil {
+				return result, fmt.Errorf("failed to parse source map: %w", err)
+			}
+			result.SourceMapHasAllSources = m.hasAllSources()
+		}
+		if args.StripLoudComments && args.OutputStyle == OutputStyleCompressed {
+			result.CSS = stripLoudComments(result.CSS)
+		}
+		if args.OutputStyle == OutputStyleExpanded {
+			result.CSS = reindent(result.CSS, args.indent())
+		}
+	case *embeddedsass.OutboundMessage_CompileResponse_Failure:
+		asJson, err := json.Marshal(resp.Failure)
+		if err != nil {
+			return result, err
+		}
+		var sassErr SassError
+		err = json.Unmarshal(asJson, &sassErr)
+		if err != nil {
+			return result, err
+		}
+		sassErr.RawURL = resp.Failure.GetSpan().GetUrl()
+		sassErr.adjustForPrelude(args.URL, args.prelude)
+		return result, sassErr
+	case nil:
+		// Either no result or a result type added in a newer protocol version.
+		return result, errors.New("compile response has no result known to this version of godartsass; try upgrading godartsass or use a Dart Sass version with a compatible protocol version")
+	default:
+		return result, fmt.Errorf("unsupported compile response result %T; try upgrading godartsass", resp)
+	}
+
+	return result, nil
+}
+
+// ExecuteTo is like Execute, but writes the CSS to w instead of setting
+// Result.CSS, and, if sourceMap is not nil, the source map to sourceMap
+// instead of setting Result.SourceMap.
+// It's a convenience only and doesn't save any allocations: Dart Sass sends
+// the CSS and source map in one message, which is read into memory before
+// anything is written.
+// Nothing is written if the compilation fails.
+func (t *Transpiler) ExecuteTo(w, sourceMap io.Writer, args Args) (Result, error) {
+	result, err := t.Execute(args)
+	if err != nil {
+		return result, err
+	}
+	css := result.CSS
+	result.CSS = ""
+	if _, err := io.WriteString(w, css); err != nil {
+		return result, err
+	}
+	if sourceMap != nil {
+		m := result.SourceMap
+		result.SourceMap = ""
+		if _, err := io.WriteString(sourceMap, m); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// LastStderr returns the tail of what the current Dart Sass process
+// has written to stderr, which may be useful when diagnosing failures.
+func (t *Transpiler) LastStderr() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.conn.(stderrTailer); ok {
+		return s.stderr()
+	}
+	return ""
+}
+
+// StatusJSON returns the state of the Transpiler and its Dart Sass process
+// as JSON, e.g. for health checks.
+func (t *Transpiler) StatusJSON() ([]byte, error) {
+	// Left empty if Dart Sass is not responding.
+	version, _ := t.dartSassVersion()
+
+	t.mu.Lock()
+	status := struct {
+		Version  DartSassVersion `json:"version"`
+		PID      int             `json:"pid"`
+		Pending  int             `json:"pending"`
+		ShutDown bool            `json:"shutDown"`
+		Stderr   string          `json:"stderr"`
+		Stats    Stats           `json:"stats"`
+	}{
+		Version:  version,
+		Pending:  len(t.pending),
+		ShutDown: t.shutdown || t.closing,
+		Stats:    t.Stats(),
+	}
+	if c, ok := t.conn.(conn); ok && c.cmd.Process != nil {
+		status.PID = c.cmd.Process.Pid
+	}
+	if s, ok := t.conn.(stderrTailer); ok {
+		status.Stderr = s.stderr()
+	}
+	t.mu.Unlock()
+
+	return json.Marshal(status)
+}
+
+// Stats holds counters over the lifetime of a Transpiler, e.g. for monitoring.
+type Stats struct {
+	// The number of warnings and deprecation warnings received from Dart Sass,
+	// including those silenced by Args.QuietDeprecations.
+	WarningsTotal     uint64 `json:"warningsTotal"`
+	DeprecationsTotal uint64 `json:"deprecationsTotal"`
+
+	// The number of calls to Execute and ExecuteContext that failed.
+	ErrorsTotal uint64 `json:"errorsTotal"`
+}
+
+// Stats returns the counters of the Transpiler.
+func (t *Transpiler) Stats() Stats {
+	return Stats{
+		WarningsTotal:     t.warningsTotal.Load(),
+		DeprecationsTotal: t.deprecationsTotal.Load(),
+		ErrorsTotal:       t.errorsTotal.Load(),
+	}
+}
+
+// SetIncludePaths sets the include paths used by all following compilations,
+// after the IncludePaths in Args, e.g. to update the search paths in watch mode.
+// It is safe to call concurrently with Execute.
+func (t *Transpiler) SetIncludePaths(paths []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.includePaths = slices.Clone(paths)
+}
+
+// RecentLogs returns the last Options.LogHistorySize log events received
+// from Dart Sass, oldest first. The events are kept across compilations
+// and restarts.
+func (t *Transpiler) RecentLogs() []LogEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	logs := make([]LogEvent, 0, len(t.recentLogs))
+	logs = append(logs, t.recentLogs[t.recentLogsStart:]...)
+	return append(logs, t.recentLogs[:t.recentLogsStart]...)
+}
+
+func (t *Transpiler) addRecentLog(e LogEvent) {
+	size := t.opts.LogHistorySize
+	if size <= 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.recentLogs) < size {
+		t.recentLogs = append(t.recentLogs, e)
+		return
+	}
+	t.recentLogs[t.recentLogsStart] = e
+	t.recentLogsStart = (t.recentLogsStart + 1) % size
+}
+
+// Warmup performs a trivial compilation to prime the Dart VM.
+// The first compilation in a new Dart Sass process is notably slower than
+// the following, so calling this in the background after Start will take
+// that latency out of the first real call to Execute.
+func (t *Transpiler) Warmup() error {
+	_, err := t.Execute(Args{Source: "a{b:c}"})
+	if err != nil {
+		return fmt.Errorf("warmup failed: %w", err)
+	}
+	return nil
+}
+
+// ExecuteRaw transpiles the string Source given in Args and returns the raw
+// protocol message holding the CompileResponse, which may be a compile failure.
+//
+// This is an advanced and unstable API for reading protocol fields not yet
+// surfaced in Result. The message types live in an internal package and may
+// change with the protocol version, use the generated getters or
+// protobuf reflection to read them.
+func (t *Transpiler) ExecuteRaw(args Args) (*embeddedsass.OutboundMessage, error) {
+	call, err := t.execute(context.Background(), &args)
+	if err != nil {
+		return nil, err
+	}
+	return call.Response, nil
+}
+
+func (t *Transpiler) execute(ctx context.Context, args *Args) (*call, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	createInboundMessage := func(seq uint32) (*embeddedsass.InboundMessage, error) {
-		if err := args.init(seq, t.opts); err != nil {
+		// This is called with mu held.
+		if len(t.includePaths) > 0 {
+			args.IncludePaths = append(slices.Clip(args.IncludePaths), t.includePaths...)
+		}
+		if err := args.init(t.opts); err != nil {
 			return nil, err
 		}
 
 		message := &embeddedsass.InboundMessage_CompileRequest_{
 			CompileRequest: &embeddedsass.InboundMessage_CompileRequest{
-				Importers: args.sassImporters,
-				Style:     args.sassOutputStyle,
-				Input: &embeddedsass.InboundMessage_CompileRequest_String_{
-					String_: &embeddedsass.InboundMessage_CompileRequest_StringInput{
-						Syntax: args.sassSourceSyntax,
-						Source: args.Source,
-						Url:    args.URL,
-					},
-				},
+				Importers:               args.sassImporters,
+				Style:                   args.sassOutputStyle,
 				SourceMap:               args.EnableSourceMap,
 				SourceMapIncludeSources: args.SourceMapIncludeSources,
 				SilenceDeprecation:      args.SilenceDeprecations,
+				FutureDeprecation:       args.FutureDeprecations,
+				QuietDeps:               args.SilenceDependencyDeprecations,
+				Verbose:                 args.Verbose,
+				Silent:                  args.Quiet,
+				GlobalFunctions:         args.sassFunctions,
+				Charset:                 args.Charset == nil || *args.Charset,
+				AlertColor:              *t.opts.AlertColor,
+				AlertAscii:              *t.opts.AlertASCII,
 			},
 		}
+		if args.Path != "" {
+			message.CompileRequest.Input = &embeddedsass.InboundMessage_CompileRequest_Path{
+				Path: args.Path,
+			}
+		} else {
+			message.CompileRequest.Input = &embeddedsass.InboundMessage_CompileRequest_String_{
+				String_: &embeddedsass.InboundMessage_CompileRequest_StringInput{
+					Syntax:   args.sassSourceSyntax,
+					Source:   args.Source,
+					Url:      args.URL,
+					Importer: args.sassEntryImporter,
+				},
+			}
+		}
+		message.CompileRequest.FatalDeprecation = args.FatalDeprecations
+		if args.RequireUse && !slices.Contains(args.FatalDeprecations, "import") {
+			message.CompileRequest.FatalDeprecation = append(slices.Clip(args.FatalDeprecations), "import")
+		}
 
 		return &embeddedsass.InboundMessage{
 			Message: message,
 		}, nil
 	}
 
+	if t.opts.IsolatePerCompile {
+		t.isolateMu.Lock()
+		defer t.isolateMu.Unlock()
+		if t.compiled {
+			if err := t.Restart(); err != nil {
+				return nil, err
+			}
+		}
+		t.compiled = true
+	}
+
 	call, err := t.newCall(createInboundMessage, args)
 	if err != nil {
-		return result, err
+		return nil, err
 	}
 
 	select {
 	case call = <-call.Done:
+	case <-ctx.Done():
+		t.cancel(call.id)
+		return nil, ctx.Err()
 	case <-time.After(t.opts.Timeout):
-		return result, errors.New("timeout waiting for Dart Sass to respond; note that this project is only compatible with the Dart Sass Binary found here: https://github.com/sass/dart-sass/releases/")
+		if t.opts.KillOnTimeout {
+			if err := t.restartIfPending(call.id); err != nil {
+				return nil, err
+			}
+		} else {
+			// Don't let Shutdown wait for a response that may never come.
+			t.cancel(call.id)
+		}
+		return nil, errors.New("timeout waiting for Dart Sass to respond; note that this project is only compatible with the Dart Sass Binary found here: https://github.com/sass/dart-sass/releases/")
 	}
 
 	if call.Error != nil {
-		return result, call.Error
+		return nil, call.Error
 	}
 
-	response := call.Response
-	csp := response.Message.(*embeddedsass.OutboundMessage_CompileResponse_)
+	return call, nil
+}
 
-	switch resp := csp.CompileResponse.Result.(type) {
-	case *embeddedsass.OutboundMessage_CompileResponse_Success:
-		result.CSS = resp.Success.Css
-		result.SourceMap = resp.Success.SourceMap
-	case *embeddedsass.OutboundMessage_CompileResponse_Failure:
-		asJson, err := json.Marshal(resp.Failure)
+// CompileDir transpiles all SCSS files below root using Execute, with args
+// as a template for every file (Source, URL and SourceSyntax are set per file).
+//
+// Partials, files with a name starting with "_", are skipped, but they can be
+// imported from the other files. Each file's directory is added first to its
+// IncludePaths.
+//
+// The returned map is keyed by output filename, the filename relative to root
+// joined with outDir and with a ".css" extension.
+// Note that no files are written.
+func (t *Transpiler) CompileDir(root, outDir string, args Args) (map[string]Result, error) {
+	results := make(map[string]Result)
+
+	err := filepath.WalkDir(root, func(filename string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return result, err
+			return err
 		}
-		var sassErr SassError
-		err = json.Unmarshal(asJson, &sassErr)
+		if d.IsDir() || filepath.Ext(filename) != ".scss" || strings.HasPrefix(d.Name(), "_") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, filename)
 		if err != nil {
-			return result, err
+			return err
 		}
-		return result, sassErr
-	default:
-		return result, fmt.Errorf("unsupported response type: %T", resp)
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return err
+		}
+		source, err := os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+
+		fileArgs := args
+		fileArgs.Source = string(source)
+		fileArgs.URL = fileURL(abs)
+		fileArgs.SourceSyntax = SourceSyntaxSCSS
+		fileArgs.IncludePaths = append([]string{filepath.Dir(abs)}, args.IncludePaths...)
+
+		result, err := t.Execute(fileArgs)
+		if err != nil {
+			return fmt.Errorf("failed to transpile %q: %w", filename, err)
+		}
+
+		results[filepath.Join(outDir, strings.TrimSuffix(rel, ".scss")+".css")] = result
+
+		return nil
+	})
+
+	return results, err
+}
+
+// Restart kills the Dart Sass process and starts a new one.
+// Any pending calls will fail with ErrRestarted.
+// The transpiler can be restarted after the Dart Sass process has died,
+// but not after Close.
+func (t *Transpiler) Restart() error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.restart()
+}
+
+// restartIfPending restarts the Dart Sass process if the call with the given
+// id is still pending, i.e. it has not completed and no other restart has
+// cleared it.
+func (t *Transpiler) restartIfPending(id uint32) error {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, found := t.pending[id]; !found {
+		return nil
 	}
 
-	return result, nil
+	return t.restart()
+}
+
+// restart must be called with both sendMu and mu held.
+func (t *Transpiler) restart() error {
+	if t.closing {
+		return ErrShutdown
+	}
+
+	if k, ok := t.conn.(killer); ok {
+		// The process is killed, so we don't care about the error.
+		_ = k.Kill()
+	} else {
+		_ = t.conn.Close()
+	}
+
+	for id, call := range t.pending {
+		call.Error = ErrRestarted
+		call.done()
+		delete(t.pending, id)
+	}
+
+	conn, err := t.startConn()
+	if err != nil {
+		t.shutdown = true
+		return err
+	}
+
+	// No calls are pending, and the new process knows nothing about the old IDs.
+	// Any late messages from the old process are dropped by input, so
+	// they can't reach the new calls reusing their IDs.
+	t.seq = 0
+	clear(t.cancelled)
+	t.conn = conn
+	t.writer = framing.NewWriter(conn)
+	t.shutdown = false
+
+	go t.input(conn)
+
+	return nil
 }
 
-func (t *Transpiler) getCall(id uint32) *call {
+// cancel removes the pending call with the given id, ignoring any
+// response to it from Dart Sass.
+func (t *Transpiler) cancel(id uint32) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	call, found := t.pending[id]
-	if !found {
-		panic(fmt.Sprintf("call with ID %d not found", id))
+	if _, found := t.pending[id]; found {
+		delete(t.pending, id)
+		t.cancelled[id] = true
 	}
-	return call
 }
 
-func (t *Transpiler) input() {
+func (t *Transpiler) input(conn byteReadWriteCloser) {
 	var err error
+	reader := framing.NewReader(conn)
 
 	for err == nil {
-		// The header is the length in bytes of the remaining message including the compilation ID.
-		var l uint64
+		var (
+			compilationID uint32
+			buf           []byte
+		)
 
-		l, err = binary.ReadUvarint(t.conn)
-		if err != nil {
-			break
+		if t.resetBuffers.CompareAndSwap(true, false) {
+			reader.Reset()
 		}
 
-		plen := int(l)
-		if len(t.msgBuf) < plen {
-			t.msgBuf = make([]byte, plen)
-		}
-
-		buf := t.msgBuf[:plen]
-
-		_, err = io.ReadFull(t.conn, buf)
+		compilationID, buf, err = reader.ReadFrame()
 		if err != nil {
 			break
 		}
 
-		v, n := binary.Uvarint(buf)
-		if n <= 0 {
-			break
-		}
-		compilationID := uint32(v)
-
-		buf = buf[n:]
-
 		var msg embeddedsass.OutboundMessage
 
 		if err = proto.Unmarshal(buf, &msg); err != nil {
 			break
 		}
 
+		t.mu.Lock()
+		stale := conn != t.conn
+		// The pending call, nil if not found, e.g. because it has timed out.
+		call := t.pending[compilationID]
+		cancelled := t.cancelled[compilationID]
+		t.mu.Unlock()
+		if stale {
+			// A late message from a process killed by restart;
+			// the IDs now belong to the new process.
+			return
+		}
+
 		switch c := msg.Message.(type) {
-		case *embeddedsass.OutboundMessage_CompileResponse_:
+		case *embeddedsass.OutboundMessage_CompileResponse_, *embeddedsass.OutboundMessage_VersionResponse_:
 			// Attach it to the correct pending call.
 			t.mu.Lock()
+			if conn != t.conn {
+				t.mu.Unlock()
+				return
+			}
 			call := t.pending[compilationID]
 			delete(t.pending, compilationID)
+			cancelled := t.cancelled[compilationID]
+			delete(t.cancelled, compilationID)
 			t.mu.Unlock()
+			if call == nil && cancelled {
+				break
+			}
 			if call == nil {
 				err = fmt.Errorf("call with ID %d not found", compilationID)
 				break
@@ -331,8 +1079,29 @@ func (t *Transpiler) input() {
 			call.Response = &msg
 			call.done()
 		case *embeddedsass.OutboundMessage_CanonicalizeRequest_:
-			call := t.getCall(compilationID)
-			resolved, resolveErr := call.importResolver.CanonicalizeURL(c.CanonicalizeRequest.GetUrl())
+			if err = t.checkRequestID(call, cancelled, compilationID, "canonicalize", c.CanonicalizeRequest.GetId()); err != nil {
+				break
+			}
+			var resolved string
+			var resolveErr error
+			if resolver := call.importResolver(c.CanonicalizeRequest.GetImporterId()); resolver != nil {
+				resolveErr = call.timeResolver(func() error {
+					return t.retryImport(func() (err error) {
+						resolved, err = resolver.CanonicalizeURL(c.CanonicalizeRequest.GetUrl())
+						return
+					})
+				})
+				if resolveErr == nil && resolved != "" {
+					call.addResolved(c.CanonicalizeRequest.GetUrl(), resolved, c.CanonicalizeRequest.GetImporterId())
+					if t.opts.OnResolverImportResolved != nil {
+						t.opts.OnResolverImportResolved(c.CanonicalizeRequest.GetUrl(), resolved)
+					}
+				}
+			} else if call == nil {
+				resolveErr = fmt.Errorf("compilation with ID %d not found", compilationID)
+			} else {
+				resolveErr = fmt.Errorf("import resolver with ID %d not found", c.CanonicalizeRequest.GetImporterId())
+			}
 
 			var response *embeddedsass.InboundMessage_CanonicalizeResponse
 			if resolveErr != nil {
@@ -356,6 +1125,7 @@ func (t *Transpiler) input() {
 			}
 
 			err = t.sendInboundMessage(
+				conn,
 				compilationID,
 				&embeddedsass.InboundMessage{
 					Message: &embeddedsass.InboundMessage_CanonicalizeResponse_{
@@ -363,10 +1133,50 @@ func (t *Transpiler) input() {
 					},
 				},
 				0)
+			call.requestAnswered("canonicalize", c.CanonicalizeRequest.GetId())
 		case *embeddedsass.OutboundMessage_ImportRequest_:
-			call := t.getCall(compilationID)
+			if err = t.checkRequestID(call, cancelled, compilationID, "import", c.ImportRequest.GetId()); err != nil {
+				break
+			}
 			url := c.ImportRequest.GetUrl()
-			imp, loadErr := call.importResolver.Load(url)
+			var imp Import
+			var loadErr error
+			if call != nil {
+				call.imports++
+			}
+			if max := t.opts.MaxImports; max > 0 && call != nil && call.imports > max {
+				loadErr = fmt.Errorf("import limit of %d exceeded", max)
+			} else if resolver := call.importResolver(c.ImportRequest.GetImporterId()); resolver != nil {
+				loadErr = call.timeResolver(func() error {
+					return t.retryImport(func() (err error) {
+						imp, err = resolver.Load(url)
+						return
+					})
+				})
+				if loadErr == nil && t.opts.StripBOM {
+					imp.Content = strings.TrimPrefix(imp.Content, utf8BOM)
+				}
+				if loadErr == nil && t.opts.OnImportContent != nil {
+					imp.Content, loadErr = t.opts.OnImportContent(url, imp.Content)
+				}
+				if loadErr == nil && t.opts.ValidateUTF8 {
+					loadErr = validateUTF8(url, imp.Content)
+				}
+				if loadErr == nil && t.opts.ErrorOnEmptyImport && strings.TrimSpace(imp.Content) == "" {
+					loadErr = fmt.Errorf("import %q has no content", url)
+				}
+				if _, ok := resolver.(variablesImportResolver); loadErr == nil && !ok && t.opts.OnModuleLoad != nil {
+					requestedURL, found := call.requestedURLs[url]
+					if !found {
+						requestedURL = url
+					}
+					t.opts.OnModuleLoad(requestedURL, url)
+				}
+			} else if call == nil {
+				loadErr = fmt.Errorf("compilation with ID %d not found", compilationID)
+			} else {
+				loadErr = fmt.Errorf("import resolver with ID %d not found", c.ImportRequest.GetImporterId())
+			}
 			sourceSyntax := embeddedsass.Syntax_value[string(imp.SourceSyntax)]
 
 			var response *embeddedsass.InboundMessage_ImportResponse
@@ -400,6 +1210,7 @@ func (t *Transpiler) input() {
 			}
 
 			err = t.sendInboundMessage(
+				conn,
 				compilationID,
 				&embeddedsass.InboundMessage{
 					Message: &embeddedsass.InboundMessage_ImportResponse_{
@@ -407,31 +1218,80 @@ func (t *Transpiler) input() {
 					},
 				},
 				0)
+			call.requestAnswered("import", c.ImportRequest.GetId())
+		case *embeddedsass.OutboundMessage_FileImportRequest_:
+			// We never register any file importers.
+			err = t.sendInboundMessage(
+				conn,
+				compilationID,
+				&embeddedsass.InboundMessage{
+					Message: &embeddedsass.InboundMessage_FileImportResponse_{
+						FileImportResponse: &embeddedsass.InboundMessage_FileImportResponse{
+							Id: c.FileImportRequest.GetId(),
+							Result: &embeddedsass.InboundMessage_FileImportResponse_Error{
+								Error: fmt.Sprintf("file importer with ID %d not found", c.FileImportRequest.GetImporterId()),
+							},
+						},
+					},
+				},
+				0)
+		case *embeddedsass.OutboundMessage_FunctionCallRequest_:
+			response := &embeddedsass.InboundMessage_FunctionCallResponse{
+				Id: c.FunctionCallRequest.GetId(),
+			}
+			if v, callErr := call.callFunction(c.FunctionCallRequest); callErr != nil {
+				response.Result = &embeddedsass.InboundMessage_FunctionCallResponse_Error{Error: callErr.Error()}
+			} else {
+				response.Result = &embeddedsass.InboundMessage_FunctionCallResponse_Success{Success: v}
+			}
+			err = t.sendInboundMessage(
+				conn,
+				compilationID,
+				&embeddedsass.InboundMessage{
+					Message: &embeddedsass.InboundMessage_FunctionCallResponse_{
+						FunctionCallResponse: response,
+					},
+				},
+				0)
 		case *embeddedsass.OutboundMessage_LogEvent_:
-			if t.opts.LogEventHandler != nil {
-				var logEvent LogEvent
-				e := c.LogEvent
-				if e.Span != nil {
-					u := e.Span.Url
-					if u == "" {
-						u = "stdin"
-					}
-					u, _ = url.QueryUnescape(u)
-					logEvent = LogEvent{
-						Type:            LogEventType(e.Type),
-						DeprecationType: stringPointerToString(e.DeprecationType),
-						Message:         fmt.Sprintf("%s:%d:%d: %s", u, e.Span.Start.Line, e.Span.Start.Column, c.LogEvent.GetMessage()),
-					}
-				} else {
-					logEvent = LogEvent{
-						Type:            LogEventType(e.Type),
-						DeprecationType: stringPointerToString(e.DeprecationType),
-						Message:         e.GetMessage(),
+			e := c.LogEvent
+			switch e.GetType() {
+			case embeddedsass.LogEventType_WARNING:
+				t.warningsTotal.Add(1)
+			case embeddedsass.LogEventType_DEPRECATION_WARNING:
+				t.deprecationsTotal.Add(1)
+			}
+			if call != nil && call.quietDeprecations && (e.GetType() == embeddedsass.LogEventType_DEPRECATION_WARNING || e.GetDeprecationType() != "") {
+				break
+			}
+			if call != nil {
+				call.diagnostics = append(call.diagnostics, newLogEventDiagnostic(e, call.displayURL(e.GetSpan().GetUrl())))
+			}
+
+			handler := t.opts.LogEventHandler
+			if e.Type == embeddedsass.LogEventType_DEBUG && t.opts.DebugHandler != nil {
+				handler = t.opts.DebugHandler
+			}
+			if handler != nil || t.opts.LogHistorySize > 0 {
+				logEvent := LogEvent{
+					CompilationID:   compilationID,
+					Type:            LogEventType(e.Type),
+					DeprecationType: stringPointerToString(e.DeprecationType),
+					Message:         e.GetMessage(),
+				}
+				if span := e.GetSpan(); span != nil {
+					logEvent.Span = &LogEventSpan{
+						URL:     span.GetUrl(),
+						Start:   newSourceLocation(span.GetStart()),
+						End:     newSourceLocation(span.GetEnd()),
+						Context: span.GetContext(),
 					}
 				}
 
-				t.opts.LogEventHandler(logEvent)
-
+				t.addRecentLog(logEvent)
+				if handler != nil {
+					handler(logEvent)
+				}
 			}
 
 		case *embeddedsass.OutboundMessage_Error:
@@ -442,19 +1302,40 @@ func (t *Transpiler) input() {
 
 	}
 
+	isEOF := err == io.EOF || strings.Contains(err.Error(), "already closed")
+
+	// A process that exits on its own, e.g. on a missing dependency when
+	// Options.VerifyStart isn't set, usually says why on stderr.
+	var stderr string
+	if s, ok := conn.(exitStderrer); ok && isEOF {
+		t.mu.Lock()
+		exited := !t.closing && conn == t.conn
+		t.mu.Unlock()
+		if exited {
+			stderr = strings.TrimSpace(s.exitStderr(exitStderrTimeout))
+		}
+	}
+
 	// Terminate pending calls.
 	t.sendMu.Lock()
 	defer t.sendMu.Unlock()
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
+	if conn != t.conn {
+		// The process was restarted and pending calls belong to the new one.
+		return
+	}
+
 	t.shutdown = true
-	isEOF := err == io.EOF || strings.Contains(err.Error(), "already closed")
 	if isEOF {
 		if t.closing {
 			err = ErrShutdown
 		} else {
 			err = io.ErrUnexpectedEOF
+			if stderr != "" {
+				err = fmt.Errorf("%w: %s", err, stderr)
+			}
 		}
 	}
 
@@ -464,6 +1345,51 @@ func (t *Transpiler) input() {
 	}
 }
 
+// checkRequestID verifies that a request with the given ID belongs to call
+// and that no request of the same kind with that ID is still outstanding,
+// i.e. not yet answered, see requestAnswered.
+// Dart Sass reuses the IDs of answered requests.
+// Requests for a call cancelled by ExecuteContext, with call nil and
+// cancelled set, are accepted.
+// This is a no-op unless Options.StrictProtocol is set.
+func (t *Transpiler) checkRequestID(call *call, cancelled bool, compilationID uint32, kind string, id uint32) error {
+	if !t.opts.StrictProtocol || (call == nil && cancelled) {
+		return nil
+	}
+	if call == nil {
+		return fmt.Errorf("protocol error: %s request %d for unknown compilation with ID %d", kind, id, compilationID)
+	}
+	if call.requestIDs[kind][id] {
+		return fmt.Errorf("protocol error: duplicate %s request ID %d in compilation with ID %d", kind, id, compilationID)
+	}
+	if call.requestIDs == nil {
+		call.requestIDs = make(map[string]map[uint32]bool)
+	}
+	if call.requestIDs[kind] == nil {
+		call.requestIDs[kind] = make(map[uint32]bool)
+	}
+	call.requestIDs[kind][id] = true
+	return nil
+}
+
+// requestAnswered marks the request of the given kind and ID as answered,
+// so its ID may be reused. call may be nil.
+func (call *call) requestAnswered(kind string, id uint32) {
+	if call != nil {
+		delete(call.requestIDs[kind], id)
+	}
+}
+
+// retryImport calls fn, retrying up to Options.ImportRetries times on error.
+func (t *Transpiler) retryImport(fn func() error) error {
+	err := fn()
+	for attempt := 0; err != nil && attempt < t.opts.ImportRetries; attempt++ {
+		time.Sleep(time.Duration(attempt+1) * t.opts.ImportRetryBackoff)
+		err = fn()
+	}
+	return err
+}
+
 func (t *Transpiler) nextSeq() uint32 {
 	t.seq++
 	// The compilation ID 0 is reserved for `VersionRequest` and `VersionResponse`,
@@ -474,11 +1400,13 @@ func (t *Transpiler) nextSeq() uint32 {
 	return t.seq
 }
 
-func (t *Transpiler) newCall(createInbound func(seq uint32) (*embeddedsass.InboundMessage, error), args Args) (*call, error) {
+func (t *Transpiler) newCall(createInbound func(seq uint32) (*embeddedsass.InboundMessage, error), args *Args) (*call, error) {
+	var conn byteReadWriteCloser
 	id, call, err := func() (uint32, *call, error) {
 		t.mu.Lock()
 		defer t.mu.Unlock()
 
+		conn = t.conn
 		id := t.nextSeq()
 		req, err := createInbound(id)
 		if err != nil {
@@ -497,12 +1425,16 @@ func (t *Transpiler) newCall(createInbound func(seq uint32) (*embeddedsass.Inbou
 		}
 
 		call := &call{
-			Request:        req,
-			Done:           make(chan *call, 1),
-			importResolver: args.ImportResolver,
+			id:                id,
+			Request:           req,
+			Done:              make(chan *call, 1),
+			importResolvers:   args.importResolvers,
+			functions:         args.functions,
+			url:               args.URL,
+			quietDeprecations: args.QuietDeprecations,
 		}
 
-		if t.shutdown || t.closing {
+		if t.shutdown || t.closing || t.draining {
 			call.Error = ErrShutdown
 			call.done()
 			return id, call, ErrShutdown
@@ -516,10 +1448,15 @@ func (t *Transpiler) newCall(createInbound func(seq uint32) (*embeddedsass.Inbou
 		return nil, err
 	}
 
-	return call, t.sendInboundMessage(id, call.Request, args.testingShouldPanicWhen)
+	return call, t.sendInboundMessage(conn, id, call.Request, args.testingShouldPanicWhen)
 }
 
-func (t *Transpiler) sendInboundMessage(compilationID uint32, message *embeddedsass.InboundMessage, testingShouldPanicWhen godartsasstesting.PanicWhen) error {
+// sendInboundMessage sends message to Dart Sass if conn is still the current
+// connection. If the process has been restarted since, the message belongs
+// to a compilation in the old process, which is either a request already
+// failed with ErrRestarted or a reply to a request from the old process,
+// and it is dropped.
+func (t *Transpiler) sendInboundMessage(conn byteReadWriteCloser, compilationID uint32, message *embeddedsass.InboundMessage, testingShouldPanicWhen godartsasstesting.PanicWhen) error {
 	t.sendMu.Lock()
 	defer t.sendMu.Unlock()
 	t.mu.Lock()
@@ -527,6 +1464,10 @@ func (t *Transpiler) sendInboundMessage(compilationID uint32, message *embeddeds
 		t.mu.Unlock()
 		return ErrShutdown
 	}
+	if conn != t.conn {
+		t.mu.Unlock()
+		return nil
+	}
 	t.mu.Unlock()
 
 	out, err := proto.Marshal(message)
@@ -539,24 +1480,10 @@ func (t *Transpiler) sendInboundMessage(compilationID uint32, message *embeddeds
 		panic("testing ShouldPanicInSendInbound1")
 	}
 
-	// Every message must begin with a varint indicating the length in bytes of
-	// the remaining message including the compilation ID
-	reqLen := uint64(len(out))
-	compilationIDLen := binary.PutUvarint(t.idBuf, uint64(compilationID))
-	headerLen := binary.PutUvarint(t.lenBuf, reqLen+uint64(compilationIDLen))
-	_, err = t.conn.Write(t.lenBuf[:headerLen])
-	if err != nil {
-		return err
-	}
-	_, err = t.conn.Write(t.idBuf[:compilationIDLen])
-	if err != nil {
+	if err := t.writer.WriteFrame(compilationID, out); err != nil {
 		return err
 	}
 
-	if _, err = t.conn.Write(out); err != nil {
-		return fmt.Errorf("failed to write payload: %w", err)
-	}
-
 	// Only set in tests.
 	if testingShouldPanicWhen.Has(godartsasstesting.ShouldPanicInSendInbound2) {
 		panic("testing ShouldPanicInSendInbound2")
@@ -566,14 +1493,90 @@ func (t *Transpiler) sendInboundMessage(compilationID uint32, message *embeddeds
 }
 
 type call struct {
-	Request        *embeddedsass.InboundMessage
-	Response       *embeddedsass.OutboundMessage
-	importResolver ImportResolver
+	id              uint32
+	Request         *embeddedsass.InboundMessage
+	Response        *embeddedsass.OutboundMessage
+	importResolvers []ImportResolver
+	functions       map[string]CustomFunction
+
+	// The URL of the entry point, if set in Args.
+	url string
+
+	// Set from Args.QuietDeprecations.
+	quietDeprecations bool
+
+	// The number of import requests received. Only accessed from input.
+	imports int
+
+	// Collected from the log events. Only accessed from input until done.
+	diagnostics []Diagnostic
+
+	// Time spent in import resolvers. Only accessed from input until done.
+	resolverDuration time.Duration
+
+	// See Result.ImportOrigins. Only accessed from input until done.
+	importOrigins map[string]int
+
+	// Maps canonical URLs to the requested URLs. Only accessed from input.
+	requestedURLs map[string]string
+
+	// The outstanding canonicalize and import request IDs by kind with
+	// Options.StrictProtocol set. Only accessed from input.
+	requestIDs map[string]map[uint32]bool
 
 	Error error
 	Done  chan *call
 }
 
+// addResolved records that the resolver with the given importer ID
+// resolved requestedURL to canonicalURL. The generated variables module is
+// not recorded.
+func (call *call) addResolved(requestedURL, canonicalURL string, importerID uint32) {
+	origin := int(importerID)
+	if _, ok := call.importResolvers[0].(variablesImportResolver); ok {
+		if origin == 1 {
+			return
+		}
+		origin--
+	}
+	if call.importOrigins == nil {
+		call.importOrigins = make(map[string]int)
+		call.requestedURLs = make(map[string]string)
+	}
+	call.importOrigins[canonicalURL] = origin
+	call.requestedURLs[canonicalURL] = requestedURL
+}
+
+// timeResolver calls fn, adding the time spent to the call's resolver duration.
+func (call *call) timeResolver(fn func() error) error {
+	start := time.Now()
+	err := fn()
+	call.resolverDuration += time.Since(start)
+	return err
+}
+
+// displayURL returns u or, for inline sources which may get a data: URL
+// containing the entire source, the entry point's URL or "stdin".
+// call may be nil.
+func (call *call) displayURL(u string) string {
+	if u != "" && !strings.HasPrefix(u, "data:") {
+		return u
+	}
+	if call != nil && call.url != "" {
+		return call.url
+	}
+	return "stdin"
+}
+
+// importResolver returns the import resolver with the given ID, nil if not found.
+// call may be nil.
+func (call *call) importResolver(id uint32) ImportResolver {
+	if call == nil || id == 0 || int(id) > len(call.importResolvers) {
+		return nil
+	}
+	return call.importResolvers[id-1]
+}
+
 func (call *call) done() {
 	select {
 	case call.Done <- call:
@@ -581,6 +1584,16 @@ func (call *call) done() {
 	}
 }
 
+func fileURL(filename string) string {
+	filename = filepath.ToSlash(filename)
+	if !strings.HasPrefix(filename, "/") {
+		// Windows.
+		filename = "/" + filename
+	}
+	u := url.URL{Scheme: "file", Path: filename}
+	return u.String()
+}
+
 func hasScheme(s string) bool {
 	u, err := url.ParseRequestURI(s)
 	if err != nil {
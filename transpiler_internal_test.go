@@ -0,0 +1,537 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bep/godartsass/v2/internal/embeddedsass"
+	qt "github.com/frankban/quicktest"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestExecuteTo(t *testing.T) {
+	c := qt.New(t)
+
+	const sourceMap = `{"version":3,"sources":[],"mappings":""}`
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newResponseHandler(compileSuccess("a{b:c}", sourceMap)))
+	defer transpiler.Close()
+
+	var css, m bytes.Buffer
+	result, err := transpiler.ExecuteTo(&css, nil, Args{Source: "a{b:c}", OutputStyle: OutputStyleCompressed})
+	c.Assert(err, qt.IsNil)
+	c.Assert(css.String(), qt.Equals, "a{b:c}")
+	c.Assert(result.CSS, qt.Equals, "")
+	c.Assert(result.SourceMap, qt.Equals, sourceMap)
+	c.Assert(result.EffectiveOutputStyle, qt.Equals, OutputStyleCompressed)
+
+	css.Reset()
+	result, err = transpiler.ExecuteTo(&css, &m, Args{Source: "a{b:c}", EnableSourceMap: true})
+	c.Assert(err, qt.IsNil)
+	c.Assert(css.String(), qt.Equals, "a{b:c}")
+	c.Assert(m.String(), qt.Equals, sourceMap)
+	c.Assert(result.SourceMap, qt.Equals, "")
+
+	_, err = transpiler.ExecuteTo(errWriter{}, nil, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "write failed")
+	_, err = transpiler.ExecuteTo(io.Discard, errWriter{}, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "write failed")
+}
+
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestResultSHA256Cached(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: "div{color:#ccc}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.SHA256(), qt.Equals, "285470827a00222b1dee1b895aac825df003c5e705967eb7ad543207c5b4752d")
+	c.Assert(result.sha256.sum, qt.Equals, "285470827a00222b1dee1b895aac825df003c5e705967eb7ad543207c5b4752d")
+
+	// A copy with other CSS shares the cache but not the hash.
+	other := result
+	other.CSS = ""
+	c.Assert(other.SHA256(), qt.Equals, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+	c.Assert(result.SHA256(), qt.Equals, "285470827a00222b1dee1b895aac825df003c5e705967eb7ad543207c5b4752d")
+}
+
+func TestExecuteContext(t *testing.T) {
+	c := qt.New(t)
+
+	// Holds back the response to the first compilation until released.
+	release := make(chan struct{})
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if compilationID == 1 {
+			go func() {
+				<-release
+				echoCompileHandler(compilationID, msg, send)
+			}()
+			return
+		}
+		echoCompileHandler(compilationID, msg, send)
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	_, err := transpiler.ExecuteContext(ctx, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, context.Canceled)
+	transpiler.mu.Lock()
+	c.Assert(transpiler.pending, qt.HasLen, 0)
+	transpiler.mu.Unlock()
+
+	// The late response is ignored.
+	close(release)
+	result, err := transpiler.ExecuteContext(context.Background(), Args{Source: "d{e:f}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "d{e:f}")
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = transpiler.ExecuteContext(ctx, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, context.DeadlineExceeded)
+}
+
+func TestExecuteContextDeadlineBeforeTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{Timeout: time.Minute})
+	defer transpiler.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := transpiler.ExecuteContext(ctx, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, context.DeadlineExceeded)
+	c.Assert(time.Since(start) < 10*time.Second, qt.IsTrue)
+}
+
+func TestExecuteRaw(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	defer transpiler.Close()
+
+	msg, err := transpiler.ExecuteRaw(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(msg.GetCompileResponse(), qt.Not(qt.IsNil))
+	c.Assert(msg.GetCompileResponse().GetSuccess().GetCss(), qt.Equals, "a{b:c}")
+}
+
+func TestWarmup(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	c.Assert(transpiler.Warmup(), qt.IsNil)
+	c.Assert(transpiler.Close(), qt.IsNil)
+	c.Assert(transpiler.Warmup(), qt.ErrorIs, ErrShutdown)
+}
+
+func TestCompile(t *testing.T) {
+	c := qt.New(t)
+
+	c.Setenv(fakeCompilerEnv, "stderr")
+	result, err := Compile(Options{DartSassEmbeddedFilename: os.Args[0], Stderr: io.Discard}, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "a{b:c}")
+
+	_, err = Compile(Options{DartSassEmbeddedFilename: os.Args[0]}, Args{OutputStyle: "NESTED"})
+	c.Assert(err, qt.ErrorMatches, `invalid OutputStyle "NESTED".*`)
+
+	c.Setenv(fakeCompilerEnv, "fail")
+	_, err = Compile(Options{DartSassEmbeddedFilename: os.Args[0], VerifyStart: true}, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "failed to start Dart Sass:.*")
+
+	_, err = Compile(Options{DartSassEmbeddedFilename: "no-such-sass-binary"}, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, `.*"no-such-sass-binary": executable file not found in \$PATH`)
+}
+
+func TestUnknownCompileResponseResult(t *testing.T) {
+	c := qt.New(t)
+
+	// A result in a newer protocol version will be an unknown field.
+	b, err := proto.Marshal(&embeddedsass.OutboundMessage_CompileResponse{})
+	c.Assert(err, qt.IsNil)
+	b = protowire.AppendTag(b, 1000, protowire.BytesType)
+	b = protowire.AppendBytes(b, []byte("new"))
+	var resp embeddedsass.OutboundMessage_CompileResponse
+	c.Assert(proto.Unmarshal(b, &resp), qt.IsNil)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newResponseHandler(&embeddedsass.OutboundMessage{
+		Message: &embeddedsass.OutboundMessage_CompileResponse_{CompileResponse: &resp},
+	}))
+	defer transpiler.Close()
+
+	_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "compile response has no result known to this version of godartsass; try upgrading.*")
+
+	// The connection is still usable.
+	_, err = transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "compile response has no result.*")
+}
+
+func TestMaxOutputBytes(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, echoCompileHandler)
+	defer transpiler.Close()
+
+	source := strings.Repeat("a{b:c}", 1000)
+
+	result, err := transpiler.Execute(Args{Source: source, MaxOutputBytes: 6000})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.HasLen, 6000)
+
+	_, err = transpiler.Execute(Args{Source: source, MaxOutputBytes: 5999})
+	c.Assert(err, qt.ErrorMatches, "CSS output of 6000 bytes exceeds MaxOutputBytes of 5999")
+}
+
+func TestLoadedURLs(t *testing.T) {
+	c := qt.New(t)
+
+	resp := compileSuccess("a{b:c}", "")
+	resp.GetCompileResponse().LoadedUrls = []string{"file:///main.scss", variablesURL, "file:///_colors.scss"}
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, newResponseHandler(resp))
+	defer transpiler.Close()
+
+	result, err := transpiler.Execute(Args{Source: "a{b:c}", URL: "file:///main.scss", Variables: map[string]string{"a": "b"}})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.LoadedURLs, qt.DeepEquals, []string{"file:///main.scss", "file:///_colors.scss"})
+}
+
+func TestSetIncludePaths(t *testing.T) {
+	c := qt.New(t)
+
+	// Responds with the include paths as CSS.
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		var paths []string
+		for _, importer := range msg.GetCompileRequest().GetImporters() {
+			paths = append(paths, importer.GetPath())
+		}
+		send(compilationID, compileSuccess(strings.Join(paths, " "), ""))
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	args := Args{Source: "a{b:c}", IncludePaths: []string{"/args"}}
+	result, err := transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "/args")
+
+	transpiler.SetIncludePaths([]string{"/base"})
+	result, err = transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "/args /base")
+	c.Assert(args.IncludePaths, qt.DeepEquals, []string{"/args"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(num int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				transpiler.SetIncludePaths([]string{"/base", fmt.Sprintf("/base%d", num)})
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				result, err := transpiler.Execute(args)
+				c.Check(err, qt.IsNil)
+				c.Check(result.CSS, qt.Matches, `/args /base( /base\d)?`)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestStats(t *testing.T) {
+	c := qt.New(t)
+
+	events := func(uint32, *embeddedsass.InboundMessage_CompileRequest) []*embeddedsass.OutboundMessage_LogEvent {
+		var events []*embeddedsass.OutboundMessage_LogEvent
+		for _, typ := range []embeddedsass.LogEventType{
+			embeddedsass.LogEventType_WARNING,
+			embeddedsass.LogEventType_WARNING,
+			embeddedsass.LogEventType_DEPRECATION_WARNING,
+			embeddedsass.LogEventType_DEBUG,
+		} {
+			events = append(events, &embeddedsass.OutboundMessage_LogEvent{Type: typ, Message: "log"})
+		}
+		return events
+	}
+	// Fails the compilations with "fail" as source.
+	handler := withLogEvents(events, func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		if msg.GetCompileRequest().GetString_().GetSource() == "fail" {
+			send(compilationID, compileFailure("boom", nil))
+			return
+		}
+		echoCompileHandler(compilationID, msg, send)
+	})
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+	defer transpiler.Close()
+
+	c.Assert(transpiler.Stats(), qt.Equals, Stats{})
+
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(transpiler.Stats(), qt.Equals, Stats{WarningsTotal: 2, DeprecationsTotal: 1})
+
+	_, err = transpiler.Execute(Args{Source: "fail"})
+	c.Assert(err, qt.ErrorMatches, ".*boom")
+	_, err = transpiler.Execute(Args{Source: "a{b:c}", QuietDeprecations: true})
+	c.Assert(err, qt.IsNil)
+	c.Assert(transpiler.Stats(), qt.Equals, Stats{WarningsTotal: 6, DeprecationsTotal: 3, ErrorsTotal: 1})
+}
+
+func TestStatusJSON(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{})
+	defer transpiler.Close()
+
+	b, err := transpiler.StatusJSON()
+	c.Assert(err, qt.IsNil)
+
+	var status map[string]any
+	c.Assert(json.Unmarshal(b, &status), qt.IsNil)
+	var keys []string
+	for k := range status {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	c.Assert(keys, qt.DeepEquals, []string{"pending", "pid", "shutDown", "stats", "stderr", "version"})
+	c.Assert(status["pid"], qt.Equals, float64(transpiler.testingCmd().Process.Pid))
+	c.Assert(status["version"].(map[string]any)["implementationName"], qt.Equals, "fake")
+	c.Assert(status["stats"], qt.DeepEquals, map[string]any{"warningsTotal": float64(0), "deprecationsTotal": float64(0), "errorsTotal": float64(0)})
+}
+
+func TestShutdown(t *testing.T) {
+	c := qt.New(t)
+
+	// Responds to compile requests when release is closed.
+	release := make(chan struct{})
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		go func() {
+			<-release
+			echoCompileHandler(compilationID, msg, send)
+		}()
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{}, handler)
+
+	done := make(chan error)
+	go func() {
+		result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+		c.Check(result.CSS, qt.Equals, "a{b:c}")
+		done <- err
+	}()
+
+	isPending := func() bool {
+		transpiler.mu.Lock()
+		defer transpiler.mu.Unlock()
+		return len(transpiler.pending) > 0
+	}
+	for !isPending() {
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdown := make(chan error)
+	go func() {
+		shutdown <- transpiler.Shutdown(context.Background())
+	}()
+
+	isDraining := func() bool {
+		transpiler.mu.Lock()
+		defer transpiler.mu.Unlock()
+		return transpiler.draining
+	}
+	for !isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, ErrShutdown)
+
+	close(release)
+	c.Assert(<-done, qt.IsNil)
+	c.Assert(<-shutdown, qt.IsNil)
+	c.Assert(transpiler.Shutdown(context.Background()), qt.Equals, ErrShutdown)
+	c.Assert(transpiler.Close(), qt.Equals, ErrShutdown)
+}
+
+func TestShutdownAfterTimeout(t *testing.T) {
+	c := qt.New(t)
+
+	noResponse := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {}
+	transpiler, _ := newFakeConnTranspiler(c, Options{Timeout: 50 * time.Millisecond}, noResponse)
+
+	_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.ErrorMatches, "timeout.*")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c.Assert(transpiler.Shutdown(ctx), qt.IsNil)
+}
+
+func TestShutdownContextExpired(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := startFakeTranspiler(c, "hang", Options{})
+
+	done := make(chan error)
+	go func() {
+		_, err := transpiler.Execute(Args{Source: "a{b:c}"})
+		done <- err
+	}()
+
+	for transpiler.TestingSeq() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	c.Assert(transpiler.Shutdown(ctx), qt.Equals, context.DeadlineExceeded)
+	c.Assert(<-done, qt.Equals, ErrShutdown)
+}
+
+func TestStrictProtocol(t *testing.T) {
+	c := qt.New(t)
+
+	// Sends two canonicalize requests, the second reusing the answered ID
+	// or for an unknown compilation, then a successful compile response.
+	newHandler := func(offset uint32) fakeHandler {
+		var (
+			compilation uint32
+			responses   int
+		)
+		return func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+			switch msg.Message.(type) {
+			case *embeddedsass.InboundMessage_CompileRequest_:
+				compilation = compilationID
+				responses = 0
+				send(compilationID, canonicalizeRequest(1, 1, "foo"))
+			case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+				responses++
+				if responses == 1 {
+					send(compilation+offset, canonicalizeRequest(1, 1, "foo"))
+					return
+				}
+				send(compilation, compileSuccess("a{b:c}", ""))
+			}
+		}
+	}
+
+	for _, test := range []struct {
+		name   string
+		offset uint32
+		errRe  string
+	}{
+		{"Reused answered ID", 0, ""},
+		{"Unknown compilation", 100, `protocol error: canonicalize request 1 for unknown compilation with ID 101`},
+	} {
+		c.Run(test.name, func(c *qt.C) {
+			args := Args{Source: `@use "foo";`, ImportResolver: fakeImportResolver{}}
+
+			transpiler, _ := newFakeConnTranspiler(c, Options{}, newHandler(test.offset))
+			defer transpiler.Close()
+			result, err := transpiler.Execute(args)
+			c.Assert(err, qt.IsNil)
+			c.Assert(result.CSS, qt.Equals, "a{b:c}")
+
+			transpiler, _ = newFakeConnTranspiler(c, Options{StrictProtocol: true}, newHandler(test.offset))
+			defer transpiler.Close()
+			result, err = transpiler.Execute(args)
+			if test.errRe == "" {
+				c.Assert(err, qt.IsNil)
+				c.Assert(result.CSS, qt.Equals, "a{b:c}")
+			} else {
+				c.Assert(err, qt.ErrorMatches, test.errRe)
+			}
+		})
+	}
+}
+
+func TestStrictProtocolCancelled(t *testing.T) {
+	c := qt.New(t)
+
+	// Never answers the first compilation. Before answering the next,
+	// sends a canonicalize request for the first.
+	var (
+		next   *embeddedsass.InboundMessage
+		nextID uint32
+	)
+	handler := func(compilationID uint32, msg *embeddedsass.InboundMessage, send fakeSender) {
+		switch msg.Message.(type) {
+		case *embeddedsass.InboundMessage_CompileRequest_:
+			if compilationID == 1 {
+				return
+			}
+			next, nextID = msg, compilationID
+			send(1, canonicalizeRequest(1, 1, "foo"))
+		case *embeddedsass.InboundMessage_CanonicalizeResponse_:
+			echoCompileHandler(nextID, next, send)
+		}
+	}
+
+	transpiler, _ := newFakeConnTranspiler(c, Options{StrictProtocol: true}, handler)
+	defer transpiler.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := transpiler.ExecuteContext(ctx, Args{Source: "a{b:c}"})
+	c.Assert(err, qt.Equals, context.DeadlineExceeded)
+
+	result, err := transpiler.Execute(Args{Source: "a{b:c}"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "a{b:c}")
+}
+
+func TestCheckRequestID(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler := &Transpiler{opts: Options{StrictProtocol: true}}
+	call := &call{}
+
+	c.Assert(transpiler.checkRequestID(call, false, 1, "canonicalize", 1), qt.IsNil)
+	// The same ID for another kind of request.
+	c.Assert(transpiler.checkRequestID(call, false, 1, "import", 1), qt.IsNil)
+	c.Assert(transpiler.checkRequestID(call, false, 1, "canonicalize", 1), qt.ErrorMatches, `protocol error: duplicate canonicalize request ID 1 in compilation with ID 1`)
+
+	call.requestAnswered("canonicalize", 1)
+	c.Assert(transpiler.checkRequestID(call, false, 1, "canonicalize", 1), qt.IsNil)
+
+	c.Assert(transpiler.checkRequestID(nil, true, 2, "import", 1), qt.IsNil)
+	c.Assert(transpiler.checkRequestID(nil, false, 2, "import", 1), qt.ErrorMatches, `protocol error: import request 1 for unknown compilation with ID 2`)
+
+	transpiler.opts.StrictProtocol = false
+	c.Assert(transpiler.checkRequestID(call, false, 1, "import", 1), qt.IsNil)
+}
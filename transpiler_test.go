@@ -5,10 +5,13 @@ package godartsass_test
 
 import (
 	"bytes"
+	"compress/gzip"
 	crand "crypto/rand"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -21,6 +24,7 @@ import (
 	"github.com/bep/godartsass/v2/internal/godartsasstesting"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 type testImportResolver struct {
@@ -76,9 +80,9 @@ func TestTranspilerVariants(t *testing.T) {
 		args   godartsass.Args
 		expect interface{}
 	}{
-		{"Output style compressed", godartsass.Options{}, godartsass.Args{Source: "div { color: #ccc; }", OutputStyle: godartsass.OutputStyleCompressed}, godartsass.Result{CSS: "div{color:#ccc}"}},
-		{"Enable Source Map", godartsass.Options{}, godartsass.Args{Source: "div{color:blue;}", URL: "file://myproject/main.scss", OutputStyle: godartsass.OutputStyleCompressed, EnableSourceMap: true}, godartsass.Result{CSS: "div{color:blue}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"file://myproject/main.scss\"],\"names\":[],\"mappings\":\"AAAA\"}"}},
-		{"Enable Source Map with sources", godartsass.Options{}, godartsass.Args{Source: "div{color:blue;}", URL: "file://myproject/main.scss", OutputStyle: godartsass.OutputStyleCompressed, EnableSourceMap: true, SourceMapIncludeSources: true}, godartsass.Result{CSS: "div{color:blue}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"file://myproject/main.scss\"],\"names\":[],\"mappings\":\"AAAA\",\"sourcesContent\":[\"div{color:blue;}\"]}"}},
+		{"Output style compressed", godartsass.Options{}, godartsass.Args{Source: "div { color: #ccc; }", OutputStyle: godartsass.OutputStyleCompressed}, godartsass.Result{CSS: "div{color:#ccc}", EffectiveOutputStyle: godartsass.OutputStyleCompressed}},
+		{"Enable Source Map", godartsass.Options{}, godartsass.Args{Source: "div{color:blue;}", URL: "file://myproject/main.scss", OutputStyle: godartsass.OutputStyleCompressed, EnableSourceMap: true}, godartsass.Result{CSS: "div{color:blue}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"file://myproject/main.scss\"],\"names\":[],\"mappings\":\"AAAA\"}", EffectiveOutputStyle: godartsass.OutputStyleCompressed}},
+		{"Enable Source Map with sources", godartsass.Options{}, godartsass.Args{Source: "div{color:blue;}", URL: "file://myproject/main.scss", OutputStyle: godartsass.OutputStyleCompressed, EnableSourceMap: true, SourceMapIncludeSources: true}, godartsass.Result{CSS: "div{color:blue}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"file://myproject/main.scss\"],\"names\":[],\"mappings\":\"AAAA\",\"sourcesContent\":[\"div{color:blue;}\"]}", SourceMapHasAllSources: true, EffectiveOutputStyle: godartsass.OutputStyleCompressed}},
 		{"Sass syntax", godartsass.Options{}, godartsass.Args{
 			Source: `$font-stack:    Helvetica, sans-serif
 $primary-color: #333
@@ -89,9 +93,15 @@ body
 `,
 			OutputStyle:  godartsass.OutputStyleCompressed,
 			SourceSyntax: godartsass.SourceSyntaxSASS,
-		}, godartsass.Result{CSS: "body{font:100% Helvetica,sans-serif;color:#333}"}},
-		{"Import resolver with source map", godartsass.Options{}, godartsass.Args{Source: "@import \"colors\";\ndiv { p { color: $white; } }", EnableSourceMap: true, ImportResolver: colorsResolver}, godartsass.Result{CSS: "div p {\n  color: white;\n}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"data:;charset=utf-8,@import%20%22colors%22;%0Adiv%20%7B%20p%20%7B%20color:%20$white;%20%7D%20%7D\",\"file:///mycolors/scss/colors_myfile.scss\"],\"names\":[],\"mappings\":\"AACM;EAAI,OCDC\"}"}},
-		{"Import resolver with indented source syntax", godartsass.Options{}, godartsass.Args{Source: "@import \"main\";\n", ImportResolver: resolverIndented}, godartsass.Result{CSS: "#main {\n  color: blue;\n}"}},
+		}, godartsass.Result{CSS: "body{font:100% Helvetica,sans-serif;color:#333}", EffectiveOutputStyle: godartsass.OutputStyleCompressed}},
+		{"Auto source syntax", godartsass.Options{}, godartsass.Args{
+			Source:       "$color: #333\nbody\n  color: $color\n",
+			URL:          "file:///myproject/main.sass",
+			OutputStyle:  godartsass.OutputStyleCompressed,
+			SourceSyntax: godartsass.SourceSyntaxAuto,
+		}, godartsass.Result{CSS: "body{color:#333}", EffectiveOutputStyle: godartsass.OutputStyleCompressed}},
+		{"Import resolver with source map", godartsass.Options{}, godartsass.Args{Source: "@import \"colors\";\ndiv { p { color: $white; } }", EnableSourceMap: true, ImportResolver: colorsResolver}, godartsass.Result{CSS: "div p {\n  color: white;\n}", SourceMap: "{\"version\":3,\"sourceRoot\":\"\",\"sources\":[\"data:;charset=utf-8,@import%20%22colors%22;%0Adiv%20%7B%20p%20%7B%20color:%20$white;%20%7D%20%7D\",\"file:///mycolors/scss/colors_myfile.scss\"],\"names\":[],\"mappings\":\"AACM;EAAI,OCDC\"}", EffectiveOutputStyle: godartsass.OutputStyleExpanded, ImportOrigins: map[string]int{"file:/mycolors/scss/colors_myfile.scss": 1}}},
+		{"Import resolver with indented source syntax", godartsass.Options{}, godartsass.Args{Source: "@import \"main\";\n", ImportResolver: resolverIndented}, godartsass.Result{CSS: "#main {\n  color: blue;\n}", EffectiveOutputStyle: godartsass.OutputStyleExpanded, ImportOrigins: map[string]int{"file:/mymain/scss/main_myfile.scss": 1}}},
 
 		// Error cases
 		{"Invalid syntax", godartsass.Options{}, godartsass.Args{Source: "div { color: $white; }"}, false},
@@ -119,7 +129,12 @@ body
 				expectedResult := test.expect.(godartsass.Result)
 				c.Assert(err, qt.IsNil)
 				// printJSON(result.SourceMap)
-				c.Assert(result, qt.Equals, expectedResult)
+				// The resolver duration varies and the loaded URLs are
+				// normalized by Dart Sass, see TestLoadedURLs.
+				c.Assert(result, qt.CmpEquals(
+					cmpopts.IgnoreUnexported(godartsass.Result{}),
+					cmpopts.IgnoreFields(godartsass.Result{}, "ImportResolverDuration", "LoadedURLs"),
+				), expectedResult)
 
 			}
 		})
@@ -127,6 +142,191 @@ body
 	}
 }
 
+type mapImportResolver map[string]string
+
+func (m mapImportResolver) CanonicalizeURL(url string) (string, error) {
+	dir, base := url[:strings.LastIndex(url, "/")+1], url[strings.LastIndex(url, "/")+1:]
+	for _, candidate := range []string{url, url + ".scss", dir + "_" + base + ".scss"} {
+		if _, found := m[candidate]; found {
+			return candidate, nil
+		}
+	}
+	return "", nil
+}
+
+func (m mapImportResolver) Load(url string) (godartsass.Import, error) {
+	return godartsass.Import{Content: m[url]}, nil
+}
+
+func TestEffectiveOutputStyle(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	result, err := transpiler.Execute(godartsass.Args{Source: "div { color: #ccc; }"})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.EffectiveOutputStyle, qt.Equals, godartsass.OutputStyleExpanded)
+
+	result, err = transpiler.Execute(godartsass.Args{Source: "div { color: #ccc; }", OutputStyle: godartsass.OutputStyleCompressed})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.EffectiveOutputStyle, qt.Equals, godartsass.OutputStyleCompressed)
+}
+
+func TestSourceMapHasAllSources(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	args := godartsass.Args{Source: "div{color:blue;}", URL: "file://myproject/main.scss", EnableSourceMap: true}
+
+	result, err := transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.SourceMapHasAllSources, qt.IsFalse)
+
+	args.SourceMapIncludeSources = true
+	result, err = transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.SourceMapHasAllSources, qt.IsTrue)
+}
+
+func TestSplitBySourceImports(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	resolver := mapImportResolver{
+		"file:///my/_button.scss": ".button { color: red; }",
+		"file:///my/_card.scss":   ".card { color: blue; }",
+	}
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:          `@use "file:///my/button"; @use "file:///my/card";`,
+		URL:             "file:///my/main.scss",
+		EnableSourceMap: true,
+		ImportResolver:  resolver,
+	})
+	c.Assert(err, qt.IsNil)
+
+	split, err := result.SplitBySource()
+	c.Assert(err, qt.IsNil)
+	c.Assert(split["file:///my/_button.scss"], qt.Contains, ".button")
+	c.Assert(split["file:///my/_button.scss"], qt.Not(qt.Contains), ".card")
+	c.Assert(split["file:///my/_card.scss"], qt.Contains, ".card")
+}
+
+func TestImportResolverBuiltinModules(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	resolver := godartsass.NewRecordingImportResolver(mapImportResolver{
+		"sass:math": "@function div($a, $b) { @return 42; }",
+	})
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:         `@use "sass:math"; div { width: math.div(10px, 2); }`,
+		OutputStyle:    godartsass.OutputStyleCompressed,
+		ImportResolver: resolver,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{width:5px}")
+	c.Assert(resolver.RequestedURLs(), qt.HasLen, 0)
+}
+
+func TestImportResolverLoadCSS(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	resolver := godartsass.NewRecordingImportResolver(mapImportResolver{
+		"theme.scss": "$color: blue !default; a { color: $color; }",
+	})
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:         `@use "sass:meta"; div { @include meta.load-css("theme", $with: (color: red)); }`,
+		OutputStyle:    godartsass.OutputStyleCompressed,
+		ImportResolver: resolver,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div a{color:red}")
+	c.Assert(resolver.LoadedURLs(), qt.DeepEquals, []string{"theme.scss"})
+}
+
+func TestCharset(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	args := godartsass.Args{
+		Source:      `div::before { content: "café" }`,
+		OutputStyle: godartsass.OutputStyleCompressed,
+	}
+
+	result, err := transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "\ufeffdiv::before{content:\"café\"}")
+
+	charset := false
+	args.Charset = &charset
+	result, err = transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, `div::before{content:"café"}`)
+}
+
+func TestEmptySource(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	for _, source := range []string{"", " \n\t\n"} {
+		for _, style := range []godartsass.OutputStyle{godartsass.OutputStyleExpanded, godartsass.OutputStyleCompressed} {
+			result, err := transpiler.Execute(godartsass.Args{Source: source, OutputStyle: style})
+			c.Assert(err, qt.IsNil)
+			c.Assert(result.CSS, qt.Equals, "")
+		}
+	}
+}
+
+func TestImportResolvers(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	resolverA := mapImportResolver{
+		"a:theme": `@use "b:colors"; div { color: colors.$moo; }`,
+	}
+	resolverB := mapImportResolver{
+		"b:colors": "$moo: #f442d1;",
+	}
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:          `@use "a:theme";`,
+		OutputStyle:     godartsass.OutputStyleCompressed,
+		ImportResolvers: []godartsass.ImportResolver{resolverA, resolverB},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#f442d1}")
+}
+
+func TestEntryImporter(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	resolver := mapImportResolver{
+		"file:///my/_sibling.scss": "$moo: #f442d1;",
+	}
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:        `@use "./sibling"; div { color: sibling.$moo; }`,
+		URL:           "file:///my/main.scss",
+		OutputStyle:   godartsass.OutputStyleCompressed,
+		EntryImporter: resolver,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#f442d1}")
+}
+
 func TestDebugWarn(t *testing.T) {
 	c := qt.New(t)
 
@@ -159,10 +359,15 @@ body {
 	c.Assert(err, qt.IsNil)
 
 	c.Assert(result.CSS, qt.Equals, "body {\n  color: #333;\n}")
-	c.Assert(events, qt.DeepEquals, []godartsass.LogEvent{
-		{Type: 2, Message: "/a/b/c.scss:6:1: foo"},
-		{Type: 0, Message: "bar"},
-	})
+	c.Assert(events, qt.HasLen, 2)
+	c.Assert(events[0].Message, qt.Equals, "foo")
+	c.Assert(events[0].Span, qt.Not(qt.IsNil))
+	c.Assert(events[0].Span.URL, qt.Equals, "/a/b/c.scss")
+	c.Assert(events[0].Span.Start.Line, qt.Equals, 6)
+	c.Assert(events[0].Span.Start.Column, qt.Equals, 1)
+	c.Assert(events[0].Span.Context, qt.Contains, `@debug "foo"`)
+	c.Assert(events[1].Type, qt.Equals, godartsass.LogEventTypeWarning)
+	c.Assert(events[1].Message, qt.Equals, "bar")
 }
 
 func TestIncludePaths(t *testing.T) {
@@ -198,6 +403,44 @@ div { p { color: $moo; } }`
 	)
 	c.Assert(err, qt.IsNil)
 	c.Assert(result.CSS, qt.Equals, "content{color:#ccc}div p{color:#f442d1}")
+	c.Assert(result.LoadedURLs, qt.HasLen, 2)
+	c.Assert(result.LoadedURLs[0], qt.Matches, "file://.*/_colors.scss")
+	c.Assert(result.LoadedURLs[1], qt.Matches, "file://.*/_content.scss")
+}
+
+func TestVirtualFilesEntry(t *testing.T) {
+	c := qt.New(t)
+
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	result, err := transpiler.Execute(godartsass.Args{
+		VirtualFiles: map[string]string{
+			"main.scss":               `@use "components/colors"; div { color: colors.$moo; }`,
+			"components/_colors.scss": `$moo: #f442d1;`,
+		},
+		Entry:       "main.scss",
+		OutputStyle: godartsass.OutputStyleCompressed,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#f442d1}")
+}
+
+func TestCompileDir(t *testing.T) {
+	c := qt.New(t)
+	root := t.TempDir()
+	c.Assert(os.MkdirAll(filepath.Join(root, "sub"), 0o755), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(root, "sub", "_colors.scss"), []byte(`$moo: #f442d1;`), 0o644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(root, "sub", "main.scss"), []byte(`@use "colors"; div { color: colors.$moo; }`), 0o644), qt.IsNil)
+	c.Assert(os.WriteFile(filepath.Join(root, "README.md"), []byte(`Not SCSS.`), 0o644), qt.IsNil)
+
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	results, err := transpiler.CompileDir(root, "/out", godartsass.Args{OutputStyle: godartsass.OutputStyleCompressed})
+	c.Assert(err, qt.IsNil)
+	c.Assert(results, qt.HasLen, 1)
+	c.Assert(results[filepath.Join("/out", "sub", "main.css")].CSS, qt.Equals, "div{color:#f442d1}")
 }
 
 func TestSilenceDeprecations(t *testing.T) {
@@ -236,6 +479,131 @@ div { p { color: $moo; } }`
 	c.Assert(result.CSS, qt.Equals, "div p{color:#f442d1}")
 }
 
+func TestArgsPath(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "_colors.scss"), []byte(`$moo: #f442d1;`), 0o644)
+	os.WriteFile(filepath.Join(dir, "main.sass"), []byte("@use \"colors\"\ndiv\n  color: colors.$moo\n"), 0o644)
+
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Path:        filepath.Join(dir, "main.sass"),
+		OutputStyle: godartsass.OutputStyleCompressed,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#f442d1}")
+}
+
+func TestRequireUse(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "_colors.scss"), []byte(`$moo: #f442d1;`), 0o644)
+	os.WriteFile(filepath.Join(dir, "_theme.scss"), []byte(`@import "colors"; div { color: $moo; }`), 0o644)
+
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	args := godartsass.Args{
+		Source:       `@use "theme";`,
+		OutputStyle:  godartsass.OutputStyleCompressed,
+		IncludePaths: []string{dir},
+	}
+
+	result, err := transpiler.Execute(args)
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#f442d1}")
+
+	args.RequireUse = true
+	_, err = transpiler.Execute(args)
+	c.Assert(err, qt.ErrorMatches, "(?s).*@import.*")
+}
+
+func TestFatalDeprecations(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "_colors.scss"), []byte(`$moo: #f442d1;`), 0o644)
+
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	_, err := transpiler.Execute(godartsass.Args{
+		Source:            `@import "colors"; div { color: $moo; }`,
+		IncludePaths:      []string{dir},
+		FatalDeprecations: []string{"import"},
+	})
+	var sassErr godartsass.SassError
+	c.Assert(errors.As(err, &sassErr), qt.IsTrue)
+}
+
+func TestVariables(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	vars := map[string]string{"primary": "#333"}
+
+	result, err := transpiler.Execute(godartsass.Args{
+		Source:      `div { color: vars.$primary; }`,
+		OutputStyle: godartsass.OutputStyleCompressed,
+		Variables:   vars,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#333}")
+
+	_, err = transpiler.Execute(godartsass.Args{
+		Source:    `div { color: $primary; }`,
+		Variables: vars,
+	})
+	c.Assert(err, qt.ErrorMatches, ".*Undefined variable.*")
+
+	_, err = transpiler.Execute(godartsass.Args{
+		Source: "div {\n  color: $undefined;\n}",
+		URL:    "file:///my/main.scss",
+	})
+	var undefinedErr godartsass.SassError
+	c.Assert(errors.As(err, &undefinedErr), qt.IsTrue)
+	c.Assert(undefinedErr.Span.Start.Line, qt.Equals, 1)
+	c.Assert(undefinedErr.Span.Start.Column, qt.Equals, 9)
+	c.Assert(err, qt.ErrorMatches, "/my/main.scss:2:10: Undefined variable.")
+
+	result, err = transpiler.Execute(godartsass.Args{
+		Source:          `div { color: $primary; }`,
+		OutputStyle:     godartsass.OutputStyleCompressed,
+		Variables:       vars,
+		VariablesGlobal: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#333}")
+
+	_, err = transpiler.Execute(godartsass.Args{
+		Source:    "div {\n  color: vars.$primary;\n  width: 1px + 1em;\n}",
+		URL:       "file:///my/main.scss",
+		Variables: vars,
+	})
+	var sassErr godartsass.SassError
+	c.Assert(errors.As(err, &sassErr), qt.IsTrue)
+	c.Assert(sassErr.Span.Start.Line, qt.Equals, 2)
+	c.Assert(sassErr.Span.Start.Offset, qt.Equals, 39)
+}
+
+func TestStripLoudCommentsOption(t *testing.T) {
+	c := qt.New(t)
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+	defer clean()
+
+	src := "/*! license */\ndiv { color: #ccc; }"
+
+	result, err := transpiler.Execute(godartsass.Args{Source: src, OutputStyle: godartsass.OutputStyleCompressed})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Contains, "/*! license */")
+
+	result, err = transpiler.Execute(godartsass.Args{Source: src, OutputStyle: godartsass.OutputStyleCompressed, StripLoudComments: true})
+	c.Assert(err, qt.IsNil)
+	c.Assert(result.CSS, qt.Equals, "div{color:#ccc}")
+}
+
 func TestTranspilerParallel(t *testing.T) {
 	c := qt.New(t)
 	transpiler, clean := newTestTranspiler(c, godartsass.Options{})
@@ -280,6 +648,39 @@ div { color: $primary-color; }`, num)
 	wg.Wait()
 }
 
+func TestTranspilerParallelFunctions(t *testing.T) {
+	c := qt.New(t)
+	pow := func(args []godartsass.Value) (godartsass.Value, error) {
+		base, _ := args[0].(godartsass.SassNumber)
+		exp, _ := args[1].(godartsass.SassNumber)
+		return godartsass.SassNumber{Value: math.Pow(base.Value, exp.Value), Numerators: base.Numerators}, nil
+	}
+	transpiler, clean := newTestTranspiler(c, godartsass.Options{
+		Functions: map[string]godartsass.CustomFunction{"pow($base, $exp)": pow},
+	})
+	defer clean()
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(num int) {
+			defer wg.Done()
+			for j := 0; j < 4; j++ {
+				result, err := transpiler.Execute(godartsass.Args{
+					Source:      fmt.Sprintf("div { width: pow(2px, %d); }", num),
+					OutputStyle: godartsass.OutputStyleCompressed,
+				})
+				c.Check(err, qt.IsNil)
+				c.Check(result.CSS, qt.Equals, fmt.Sprintf("div{width:%dpx}", 1<<num))
+				if c.Failed() {
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
 func TestTranspilerParallelImportResolver(t *testing.T) {
 	c := qt.New(t)
 
@@ -453,6 +854,18 @@ func BenchmarkTranspiler(b *testing.B) {
 		runBench(b, t)
 	})
 
+	b.Run("SCSS ExecuteTo", func(b *testing.B) {
+		t := newTester(b, godartsass.Options{})
+		defer t.clean()
+		b.ResetTimer()
+		for n := 0; n < b.N; n++ {
+			_, err := t.transpiler.ExecuteTo(io.Discard, nil, godartsass.Args{Source: t.sources[n]})
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
 	// This is the obviously much slower way of doing it.
 	b.Run("Start and Execute", func(b *testing.B) {
 		for n := 0; n < b.N; n++ {
@@ -482,6 +895,63 @@ func BenchmarkTranspiler(b *testing.B) {
 	})
 }
 
+// Measures the first Execute after Start, with and without Warmup.
+func BenchmarkFirstExecute(b *testing.B) {
+	args := godartsass.Args{Source: "nav { ul { margin: 0; } li { display: inline-block; } }"}
+
+	run := func(b *testing.B, warmup bool) {
+		c := qt.New(b)
+		for n := 0; n < b.N; n++ {
+			b.StopTimer()
+			transpiler, clean := newTestTranspiler(c, godartsass.Options{})
+			if warmup {
+				c.Assert(transpiler.Warmup(), qt.IsNil)
+			}
+			b.StartTimer()
+			if _, err := transpiler.Execute(args); err != nil {
+				b.Fatal(err)
+			}
+			b.StopTimer()
+			clean()
+		}
+	}
+
+	b.Run("Without Warmup", func(b *testing.B) {
+		run(b, false)
+	})
+
+	b.Run("With Warmup", func(b *testing.B) {
+		run(b, true)
+	})
+}
+
+func TestResultSHA256(t *testing.T) {
+	c := qt.New(t)
+
+	result := godartsass.Result{CSS: "div{color:#ccc}"}
+	c.Assert(result.SHA256(), qt.Equals, "285470827a00222b1dee1b895aac825df003c5e705967eb7ad543207c5b4752d")
+	c.Assert(result.SHA256(), qt.Equals, "285470827a00222b1dee1b895aac825df003c5e705967eb7ad543207c5b4752d")
+
+	var empty godartsass.Result
+	c.Assert(empty.SHA256(), qt.Equals, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+}
+
+func TestResultGzipCSS(t *testing.T) {
+	c := qt.New(t)
+
+	result := godartsass.Result{CSS: "div{color:#ccc}"}
+	b, err := result.GzipCSS(gzip.BestCompression)
+	c.Assert(err, qt.IsNil)
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	c.Assert(err, qt.IsNil)
+	css, err := io.ReadAll(r)
+	c.Assert(err, qt.IsNil)
+	c.Assert(string(css), qt.Equals, result.CSS)
+
+	_, err = result.GzipCSS(42)
+	c.Assert(err, qt.IsNotNil)
+}
+
 func TestVersion(t *testing.T) {
 	c := qt.New(t)
 
@@ -0,0 +1,118 @@
+// Copyright 2024 Bjørn Erik Pedersen
+// SPDX-License-Identifier: MIT
+
+package godartsass
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// variablesURL is the URL of the module holding Args.Variables.
+const variablesURL = "godartsass:variables"
+
+// variablesImportResolver resolves the generated variables module.
+type variablesImportResolver struct {
+	content string
+}
+
+func newVariablesImportResolver(vars map[string]string) (variablesImportResolver, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		value := vars[name]
+		if !isVariableName(name) {
+			return variablesImportResolver{}, &InvalidOptionError{Field: "Variables", Value: name}
+		}
+		if !isVariableValue(value) {
+			return variablesImportResolver{}, &InvalidOptionError{Field: "Variables", Value: value}
+		}
+		fmt.Fprintf(&b, "$%s: %s;\n", name, value)
+	}
+
+	return variablesImportResolver{content: b.String()}, nil
+}
+
+func (r variablesImportResolver) CanonicalizeURL(url string) (string, error) {
+	if url == variablesURL {
+		return variablesURL, nil
+	}
+	return "", nil
+}
+
+func (r variablesImportResolver) Load(url string) (Import, error) {
+	return Import{Content: r.content, SourceSyntax: SourceSyntaxSCSS}, nil
+}
+
+// variablesNamespace is the namespace of the variables module unless
+// Args.VariablesGlobal is set.
+const variablesNamespace = "vars"
+
+// variablesPrelude returns the line to prepend to the entry point to
+// load the variables module.
+func variablesPrelude(syntax SourceSyntax, global bool) string {
+	namespace := variablesNamespace
+	if global {
+		namespace = "*"
+	}
+	line := fmt.Sprintf("@use %q as %s", variablesURL, namespace)
+	if syntax != SourceSyntaxSASS {
+		line += ";"
+	}
+	return line + "\n"
+}
+
+// isVariableName reports whether s is a valid Sass identifier without
+// the leading $.
+func isVariableName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, r := range s {
+		switch {
+		case r == '_' || r == '-' || r >= 0x80:
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// isVariableValue reports whether s is a single non-empty Sass expression,
+// i.e. it cannot end the declaration or start a new block outside of a
+// quoted string.
+func isVariableValue(s string) bool {
+	if strings.TrimSpace(s) == "" {
+		return false
+	}
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\n' || c == '\r':
+			return false
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ';' || c == '{' || c == '}':
+			return false
+		}
+	}
+	return quote == 0
+}